@@ -0,0 +1,114 @@
+package enmime
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// AuthResult holds the result of a single authentication method from an Authentication-Results
+// header, as described by RFC 8601.
+type AuthResult struct {
+	AuthServID string            // The authserv-id of the header, identifying the checking host
+	Method     string            // The method in lowercase, ie "spf", "dkim" or "dmarc"
+	Result     string            // The result in lowercase, ie "pass", "fail" or "none"
+	Reason     string            // The reason given for the result, if any
+	Properties map[string]string // Properties keyed by "ptype.property", ie "header.d"
+}
+
+// AuthenticationResults parses every Authentication-Results header of the message, returning a
+// result for each method listed, in order.  Comments are removed, and quoted values unquoted.
+// Headers stating that no authentication was performed, and malformed method results, are
+// skipped.
+func (e *Envelope) AuthenticationResults() []AuthResult {
+	var results []AuthResult
+	for _, value := range e.GetHeaderValues(hnAuthenticationResults) {
+		results = append(results, parseAuthResults(value)...)
+	}
+	return results
+}
+
+// parseAuthResults parses the value of a single Authentication-Results header.
+func parseAuthResults(value string) []AuthResult {
+	segments := splitUnquoted(stripComments(value), func(r rune) bool { return r == ';' })
+	if len(segments) == 0 {
+		return nil
+	}
+	// The authserv-id may be followed by a version
+	var servID string
+	if fields := strings.Fields(segments[0]); len(fields) > 0 {
+		servID = fields[0]
+	}
+
+	var results []AuthResult
+	for _, seg := range segments[1:] {
+		tokens := splitUnquoted(seg, isWhiteSpaceRune)
+		if len(tokens) == 0 || strings.EqualFold(tokens[0], "none") {
+			continue
+		}
+		method, result := splitAuthParam(tokens[0])
+		if result == "" {
+			continue
+		}
+		if i := strings.IndexByte(method, '/'); i != -1 {
+			// Remove the method version
+			method = method[:i]
+		}
+		ar := AuthResult{
+			AuthServID: servID,
+			Method:     strings.ToLower(method),
+			Result:     strings.ToLower(result),
+			Properties: make(map[string]string),
+		}
+		for _, token := range tokens[1:] {
+			key, val := splitAuthParam(token)
+			if strings.EqualFold(key, "reason") {
+				ar.Reason = val
+			} else if strings.Contains(key, ".") {
+				ar.Properties[strings.ToLower(key)] = val
+			}
+		}
+		results = append(results, ar)
+	}
+	return results
+}
+
+// splitAuthParam splits a "key=value" token, unquoting the value.
+func splitAuthParam(token string) (key, value string) {
+	i := strings.IndexByte(token, '=')
+	if i == -1 {
+		return token, ""
+	}
+	key, value = strings.TrimSpace(token[:i]), strings.TrimSpace(token[i+1:])
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = strings.Replace(value[1:len(value)-1], `\"`, `"`, -1)
+	}
+	return key, value
+}
+
+// splitUnquoted splits s at each rune for which sep returns true, ignoring those within quoted
+// strings.  Empty fields are omitted, and the remainder trimmed of whitespace.
+func splitUnquoted(s string, sep func(rune) bool) []string {
+	var fields []string
+	quoted, escaped := false, false
+	start := 0
+	add := func(end int) {
+		if f := strings.TrimSpace(s[start:end]); f != "" {
+			fields = append(fields, f)
+		}
+	}
+	for i, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\' && quoted:
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+		case !quoted && sep(r):
+			add(i)
+			start = i + utf8.RuneLen(r)
+		}
+	}
+	add(len(s))
+	return fields
+}
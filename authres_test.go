@@ -0,0 +1,83 @@
+package enmime
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEnvelopeAuthenticationResults(t *testing.T) {
+	raw := "Authentication-Results: mx.inbucket.org;\r\n" +
+		"       spf=pass (inbucket.org: domain of a@example.com designates 192.0.2.1)\r\n" +
+		"         smtp.mailfrom=a@example.com;\r\n" +
+		"       dkim=pass header.i=@example.com header.s=sel1 header.b=\"Ab+c;d\";\r\n" +
+		"       DMARC=fail reason=\"policy; p=reject\" (p=REJECT) header.from=example.com\r\n" +
+		"Authentication-Results: relay.inbucket.org 1; dkim/2=neutral header.d=example.org\r\n" +
+		"Authentication-Results: other.inbucket.org; none\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"Body\r\n"
+	e, err := ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+
+	want := []AuthResult{
+		{
+			AuthServID: "mx.inbucket.org",
+			Method:     "spf",
+			Result:     "pass",
+			Properties: map[string]string{"smtp.mailfrom": "a@example.com"},
+		},
+		{
+			AuthServID: "mx.inbucket.org",
+			Method:     "dkim",
+			Result:     "pass",
+			Properties: map[string]string{
+				"header.i": "@example.com",
+				"header.s": "sel1",
+				"header.b": "Ab+c;d",
+			},
+		},
+		{
+			AuthServID: "mx.inbucket.org",
+			Method:     "dmarc",
+			Result:     "fail",
+			Reason:     "policy; p=reject",
+			Properties: map[string]string{"header.from": "example.com"},
+		},
+		{
+			AuthServID: "relay.inbucket.org",
+			Method:     "dkim",
+			Result:     "neutral",
+			Properties: map[string]string{"header.d": "example.org"},
+		},
+	}
+	got := e.AuthenticationResults()
+	if len(got) != len(want) {
+		t.Fatalf("Got %v results, want %v: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Errorf("Result %v\ngot : %+v\nwant: %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestSplitUnquoted(t *testing.T) {
+	testCases := []struct {
+		input string
+		want  []string
+	}{
+		{"a; b ;c", []string{"a", "b", "c"}},
+		{`a="x;y"; b`, []string{`a="x;y"`, "b"}},
+		{`a="x\";y"; b`, []string{`a="x\";y"`, "b"}},
+		{";;", nil},
+	}
+	for _, tc := range testCases {
+		got := splitUnquoted(tc.input, func(r rune) bool { return r == ';' })
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("splitUnquoted(%q) got: %q, want: %q", tc.input, got, tc.want)
+		}
+	}
+}
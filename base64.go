@@ -6,11 +6,15 @@ import (
 
 // base64Cleaner helps work around bugs in Go's built-in base64 decoder by stripping out
 // whitespace that would cause Go to lose count of things and issue an "illegal base64 data at
-// input byte..." error
+// input byte..." error.  Other characters outside of the base64 alphabet are also removed, and
+// missing padding is added at the end of the input.
 type base64Cleaner struct {
-	in  io.Reader
-	buf [1024]byte
-	//count int64
+	in       io.Reader
+	buf      [1024]byte
+	count    int64  // Number of base64 characters passed through
+	padding  []byte // Padding remaining to be written after in reached EOF
+	eof      bool   // in has reached EOF
+	repaired bool   // Invalid characters were removed, or padding added
 }
 
 // newBase64Cleaner returns a Base64Cleaner object for the specified reader.  Base64Cleaner
@@ -21,6 +25,16 @@ func newBase64Cleaner(r io.Reader) *base64Cleaner {
 
 // Read method for io.Reader interface.
 func (qp *base64Cleaner) Read(p []byte) (n int, err error) {
+	if qp.eof {
+		// Write any remaining padding
+		n = copy(p, qp.padding)
+		qp.padding = qp.padding[n:]
+		if len(qp.padding) == 0 {
+			return n, io.EOF
+		}
+		return n, nil
+	}
+
 	// Size our slice to theirs
 	size := len(qp.buf)
 	if len(p) < size {
@@ -29,15 +43,48 @@ func (qp *base64Cleaner) Read(p []byte) (n int, err error) {
 	buf := qp.buf[:size]
 	bn, err := qp.in.Read(buf)
 	for i := 0; i < bn; i++ {
-		switch buf[i] {
-		case ' ', '\t', '\r', '\n':
+		switch b := buf[i]; {
+		case b == ' ' || b == '\t' || b == '\r' || b == '\n':
 			// Strip these
-		default:
-			p[n] = buf[i]
+		case isBase64Byte(b):
+			p[n] = b
 			n++
+		default:
+			// Strip line noise
+			qp.repaired = true
+		}
+	}
+	qp.count += int64(n)
+
+	if err == io.EOF {
+		qp.eof = true
+		// Pad to an even quad
+		switch qp.count % 4 {
+		case 2:
+			qp.padding = []byte("==")
+		case 3:
+			qp.padding = []byte("=")
+		}
+		if len(qp.padding) > 0 {
+			qp.repaired = true
+			// Padding will be written by the next call
+			return n, nil
 		}
 	}
-	// Count may be useful if I need to pad to even quads
-	//qp.count += int64(n)
 	return n, err
 }
+
+// isBase64Byte returns true if b is part of the standard base64 alphabet, including padding.
+func isBase64Byte(b byte) bool {
+	switch {
+	case b >= 'A' && b <= 'Z':
+		return true
+	case b >= 'a' && b <= 'z':
+		return true
+	case b >= '0' && b <= '9':
+		return true
+	case b == '+' || b == '/' || b == '=':
+		return true
+	}
+	return false
+}
@@ -2,19 +2,87 @@ package enmime
 
 import (
 	"bytes"
+	"encoding/base64"
+	"io/ioutil"
 	"strings"
 	"testing"
 )
 
 func TestBase64Cleaner(t *testing.T) {
-	in := "\tA B\r\nC"
-	want := "ABC"
-	cleaner := newBase64Cleaner(strings.NewReader(in))
-	buf := new(bytes.Buffer)
-	_, _ = buf.ReadFrom(cleaner)
-
-	got := buf.String()
-	if got != want {
-		t.Error("got:", got, "want:", want)
+	ttable := []struct {
+		input, want string
+		repaired    bool
+	}{
+		{"\tA B\r\nC", "ABC=", true},
+		{"QUJD\r\nREVG\r\n", "QUJDREVG", false},
+		{"QU JD RE\r\nVG", "QUJDREVG", false},
+		{"QU*JD!RE\x00VG", "QUJDREVG", true},
+		{"QUJDREU", "QUJDREU=", true},
+		{"QUJDRE\r\n", "QUJDRE==", true},
+		{"QUJDRE==", "QUJDRE==", false},
+	}
+
+	for _, tt := range ttable {
+		cleaner := newBase64Cleaner(strings.NewReader(tt.input))
+		buf := new(bytes.Buffer)
+		_, _ = buf.ReadFrom(cleaner)
+
+		got := buf.String()
+		if got != tt.want {
+			t.Errorf("Cleaned %q got: %q, want: %q", tt.input, got, tt.want)
+		}
+		if cleaner.repaired != tt.repaired {
+			t.Errorf("Cleaned %q repaired: %v, want: %v", tt.input, cleaner.repaired, tt.repaired)
+		}
+	}
+}
+
+// TestBase64CleanerSmallReads ensures padding is written when the destination is nearly full
+func TestBase64CleanerSmallReads(t *testing.T) {
+	cleaner := newBase64Cleaner(strings.NewReader("QUJDRE"))
+	got, err := ioutil.ReadAll(base64.NewDecoder(base64.StdEncoding, cleaner))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "ABCD" {
+		t.Errorf("got: %q, want: %q", got, "ABCD")
+	}
+}
+
+func TestBase64TolerantPart(t *testing.T) {
+	ttable := []struct {
+		body     string
+		repaired bool
+	}{
+		{"SGVsbG8g V29y bGQh\r\nIQ==\r\n", false},
+		{"SGVsbG8gV29y\r\nbGQhIQ\r\n", true},
+		{"SGVsbG8g%V29y\r\nbGQhIQ=\r\n", true},
+	}
+
+	for _, tt := range ttable {
+		raw := "Content-Type: application/octet-stream\r\n" +
+			"Content-Transfer-Encoding: base64\r\n" +
+			"\r\n" + tt.body
+		p, err := ReadParts(strings.NewReader(raw))
+		if err != nil {
+			t.Fatal("Unexpected parse error:", err)
+		}
+
+		want := "Hello World!!"
+		if string(p.Content) != want {
+			t.Errorf("Content for %q got: %q, want: %q", tt.body, p.Content, want)
+		}
+		wantErrs := 0
+		if tt.repaired {
+			wantErrs = 1
+		}
+		if len(p.Errors) != wantErrs {
+			t.Fatalf("Got %v p.Errors for %q, want %v", len(p.Errors), tt.body, wantErrs)
+		}
+		if wantErrs > 0 {
+			if p.Errors[0].Name != string(errorContentEncoding) || p.Errors[0].Severe {
+				t.Errorf("Got error %v, want a %q warning", p.Errors[0].String(), errorContentEncoding)
+			}
+		}
 	}
 }
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"strings"
 )
 
 // This constant needs to be at least 76 for this package to work correctly.  This is because
@@ -21,6 +22,7 @@ type boundaryReader struct {
 	prefix    []byte        // MIME boundary prefix
 	final     []byte        // Final boundary prefix
 	buffer    *bytes.Buffer // Content waiting to be read
+	preamble  *bytes.Buffer // Content discarded before the first delimiter
 }
 
 // newBoundaryReader returns an initialized boundaryReader
@@ -32,6 +34,7 @@ func newBoundaryReader(reader *bufio.Reader, boundary string) *boundaryReader {
 		prefix:   fullBoundary[1 : len(fullBoundary)-2],
 		final:    fullBoundary[1:],
 		buffer:   new(bytes.Buffer),
+		preamble: new(bytes.Buffer),
 	}
 }
 
@@ -59,7 +62,10 @@ func (b *boundaryReader) Read(dest []byte) (n int, err error) {
 		}
 	} else {
 		// No boundary found, move forward a safe distance
-		if nCopy = len(peek) - len(b.nlPrefix) - 1; nCopy <= 0 {
+		if peekEOF && len(bytes.TrimSpace(peek)) > 0 {
+			// No boundary will follow, pass along the remaining content before reporting it
+			nCopy = len(peek)
+		} else if nCopy = len(peek) - len(b.nlPrefix) - 1; nCopy <= 0 {
 			nCopy = 0
 			if peekEOF {
 				// No more peek space remaining and no boundary found
@@ -95,6 +101,10 @@ func (b *boundaryReader) Next() (bool, error) {
 		if err != nil && err != io.EOF {
 			return false, err
 		}
+		if b.partsRead == 0 {
+			// Retain the preamble, it may be needed to recover from a mistyped boundary
+			b.preamble.Write(line)
+		}
 		if len(line) > 0 && (line[0] == '\r' || line[0] == '\n') {
 			// Blank line
 			continue
@@ -121,6 +131,30 @@ func (b *boundaryReader) Next() (bool, error) {
 	}
 }
 
+// guessBoundary returns the most frequent boundary-like line in buf, for use when the declared
+// boundary could not be found.  Lines starting with "--" are considered, ignoring any trailing
+// "--" and whitespace; ties go to the line seen first.  An empty string is returned if no
+// candidate is found.
+func guessBoundary(buf []byte) string {
+	counts := make(map[string]int)
+	var best string
+	for _, line := range bytes.Split(buf, []byte("\n")) {
+		line = bytes.TrimRight(line, " \t\r")
+		if !bytes.HasPrefix(line, []byte("--")) {
+			continue
+		}
+		candidate := string(bytes.TrimSuffix(line[2:], []byte("--")))
+		if candidate == "" || len(candidate) > 70 || strings.TrimSpace(candidate) != candidate {
+			continue
+		}
+		counts[candidate]++
+		if counts[candidate] > counts[best] {
+			best = candidate
+		}
+	}
+	return best
+}
+
 // isDelimiter returns true for --BOUNDARY\r\n but not --BOUNDARY--
 func (b *boundaryReader) isDelimiter(buf []byte) bool {
 	idx := bytes.Index(buf, b.prefix)
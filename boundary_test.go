@@ -290,14 +290,19 @@ func TestBoundaryReaderNoTerminator(t *testing.T) {
 		t.Fatal("Next() = false, want: true")
 	}
 
-	// Second part should error
-	want := "expecting boundary"
-	next, err = br.Next()
-	if err == nil {
-		t.Fatal("Error was nil, wanted:", want)
+	// Content is returned before the missing terminator is reported
+	output, err := ioutil.ReadAll(br)
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("ReadAll() err = %v, want: %v", err, io.ErrUnexpectedEOF)
+	}
+	if got, want := string(output), "1111\r\n"; got != want {
+		t.Errorf("ReadAll() got: %q, want: %q", got, want)
 	}
-	if !strings.Contains(err.Error(), want) {
-		t.Fatalf("err = %v, want: %v", err, want)
+
+	// There is no second part
+	next, err = br.Next()
+	if err != io.EOF {
+		t.Fatalf("err = %v, want: %v", err, io.EOF)
 	}
 	if next {
 		t.Fatalf("Next() = true, want: false")
@@ -415,3 +420,22 @@ func TestBoundaryReaderBufferBoundaryCross(t *testing.T) {
 		t.Errorf("ReadAll() got: %q, want: %q", got, want)
 	}
 }
+
+func TestGuessBoundary(t *testing.T) {
+	testCases := []struct {
+		input, want string
+	}{
+		{"", ""},
+		{"no boundaries here\r\n", ""},
+		{"--\r\n-- \r\n", ""},
+		{"--one\r\na\r\n--one\r\nb\r\n--one--\r\n", "one"},
+		{"--one  \r\na\r\n--two\r\n--one--\r\n", "one"},
+		{"--first\r\n--second\r\n", "first"},
+		{"--outer\r\n--inner\r\n--inner--\r\n--outer--\r\n--inner\r\n", "inner"},
+	}
+	for _, tc := range testCases {
+		if got := guessBoundary([]byte(tc.input)); got != tc.want {
+			t.Errorf("guessBoundary(%q) got: %q, want: %q", tc.input, got, tc.want)
+		}
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"io/ioutil"
 	"regexp"
 	"strings"
+	"sync"
+	"unicode/utf8"
 
 	"golang.org/x/text/encoding"
 	"golang.org/x/text/encoding/charmap"
@@ -204,6 +206,8 @@ var encodings = map[string]struct {
 	"iso-ir-58":           {simplifiedchinese.GBK, "gbk"},
 	"x-gbk":               {simplifiedchinese.GBK, "gbk"},
 	"gb18030":             {simplifiedchinese.GB18030, "gb18030"},
+	"cp54936":             {simplifiedchinese.GB18030, "gb18030"},
+	"windows-54936":       {simplifiedchinese.GB18030, "gb18030"},
 	"hz-gb-2312":          {simplifiedchinese.HZGB2312, "hz-gb-2312"},
 	"big5":                {traditionalchinese.Big5, "big5"},
 	"big5-hkscs":          {traditionalchinese.Big5, "big5"},
@@ -215,6 +219,9 @@ var encodings = map[string]struct {
 	"x-euc-jp":            {japanese.EUCJP, "euc-jp"},
 	"csiso2022jp":         {japanese.ISO2022JP, "iso-2022-jp"},
 	"iso-2022-jp":         {japanese.ISO2022JP, "iso-2022-jp"},
+	"iso2022jp":           {japanese.ISO2022JP, "iso-2022-jp"},
+	"x-iso-2022-jp":       {japanese.ISO2022JP, "iso-2022-jp"},
+	"x-iso2022jp":         {japanese.ISO2022JP, "iso-2022-jp"},
 	"csshiftjis":          {japanese.ShiftJIS, "shift_jis"},
 	"ms_kanji":            {japanese.ShiftJIS, "shift_jis"},
 	"shift-jis":           {japanese.ShiftJIS, "shift_jis"},
@@ -222,6 +229,9 @@ var encodings = map[string]struct {
 	"sjis":                {japanese.ShiftJIS, "shift_jis"},
 	"windows-31j":         {japanese.ShiftJIS, "shift_jis"},
 	"x-sjis":              {japanese.ShiftJIS, "shift_jis"},
+	"cp932":               {japanese.ShiftJIS, "shift_jis"},
+	"ms932":               {japanese.ShiftJIS, "shift_jis"},
+	"x-ms-cp932":          {japanese.ShiftJIS, "shift_jis"},
 	"cseuckr":             {korean.EUCKR, "euc-kr"},
 	"csksc56011987":       {korean.EUCKR, "euc-kr"},
 	"euc-kr":              {korean.EUCKR, "euc-kr"},
@@ -251,6 +261,10 @@ var encodings = map[string]struct {
 	"136":                 {traditionalchinese.Big5, "big5"}, // same as chinese big5
 }
 
+// windowsCharsetRegexp matches the many spellings of the Windows code pages seen in the wild, ie
+// "windows1251", "win-1251", "cp-1251" or "ms_1251".
+var windowsCharsetRegexp = regexp.MustCompile(`^(?:x-)?(?:windows|win|cp|ms)[-_ ]?(125[0-8])$`)
+
 var metaTagCharsetRegexp = regexp.MustCompile(
 	`(?i)<meta.*charset="?\s*(?P<charset>[a-zA-Z0-9_.:-]+)\s*"?`)
 var metaTagCharsetIndex int
@@ -271,12 +285,10 @@ func convertToUTF8String(charset string, textBytes []byte) (string, error) {
 	if strings.ToLower(charset) == "utf-8" {
 		return string(textBytes), nil
 	}
-	csentry, ok := encodings[strings.ToLower(charset)]
-	if !ok {
-		return "", fmt.Errorf("Unsupported charset %q", charset)
+	reader, err := charsetReader(charset, bytes.NewReader(textBytes))
+	if err != nil {
+		return "", err
 	}
-	input := bytes.NewReader(textBytes)
-	reader := transform.NewReader(input, csentry.e.NewDecoder())
 	output, err := ioutil.ReadAll(reader)
 	if err != nil {
 		return "", err
@@ -292,11 +304,48 @@ func newCharsetReader(charset string, input io.Reader) (io.Reader, error) {
 	if strings.ToLower(charset) == "utf-8" {
 		return input, nil
 	}
-	csentry, ok := encodings[strings.ToLower(charset)]
+	e, _, ok := lookupCharset(charset)
 	if !ok {
 		return nil, fmt.Errorf("Unsupported charset %q", charset)
 	}
-	return transform.NewReader(input, csentry.e.NewDecoder()), nil
+	return transform.NewReader(input, e.NewDecoder()), nil
+}
+
+// lookupCharset finds the encoding for the named charset, accepting non-standard spellings of the
+// Windows code pages.  The canonical name of the charset is returned along with its encoding.
+func lookupCharset(charset string) (e encoding.Encoding, name string, ok bool) {
+	label := strings.ToLower(strings.TrimSpace(charset))
+	if m := windowsCharsetRegexp.FindStringSubmatch(label); m != nil {
+		label = "windows-" + m[1]
+	}
+	cs, ok := encodings[label]
+	return cs.e, cs.name, ok
+}
+
+var (
+	charsetReaderMu sync.RWMutex
+	charsetReaderFn = newCharsetReader
+)
+
+// SetCharsetReader replaces the function used to create readers converting header and body text
+// from a named charset into UTF-8, allowing applications to supply their own charset tables.
+// Passing nil restores enmime's built-in conversion.
+func SetCharsetReader(fn func(charset string, input io.Reader) (io.Reader, error)) {
+	charsetReaderMu.Lock()
+	defer charsetReaderMu.Unlock()
+	if fn == nil {
+		fn = newCharsetReader
+	}
+	charsetReaderFn = fn
+}
+
+// charsetReader returns a reader converting input from charset into UTF-8, using the function
+// installed by SetCharsetReader.
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	charsetReaderMu.RLock()
+	fn := charsetReaderFn
+	charsetReaderMu.RUnlock()
+	return fn(charset, input)
 }
 
 // Look for charset in the html meta tag (v4.01 and v5)
@@ -308,3 +357,102 @@ func findCharsetInHTML(html string) string {
 
 	return ""
 }
+
+// DetectedCharset returns the lowercase name of the charset the content of p appears to be encoded
+// in.  The declared Charset, normalized to its canonical name, is returned unless it is missing or
+// clearly wrong: a byte order mark, content that is not valid for the declared charset, or content
+// that decodes to mojibake cause a different charset to be guessed.  A warning is recorded on
+// parsed text parts when the guess differs from a declared charset.
+func (p *Part) DetectedCharset() string {
+	detected, _ := p.detectCharset()
+	return detected
+}
+
+// detectCharset implements DetectedCharset, also returning the canonical declared charset.
+func (p *Part) detectCharset() (detected, declared string) {
+	declared = strings.ToLower(strings.TrimSpace(p.Charset))
+	if _, name, ok := lookupCharset(declared); ok {
+		declared = name
+	}
+	return detectCharset(p.Content, declared, p.converted), declared
+}
+
+// checkDetectedCharset adds a warning to p if the charset its content appears to be encoded in
+// differs from the declared Charset.
+func (p *Part) checkDetectedCharset() {
+	detected, declared := p.detectCharset()
+	if declared == "" || detected == declared {
+		return
+	}
+	p.addWarning(
+		errorCharsetConversion,
+		"Declared charset %q appears to be %q",
+		p.Charset,
+		detected)
+}
+
+// detectCharset implements DetectedCharset.  If converted is true, b has already been converted
+// from declared to UTF-8, and only mojibake may be detected.
+func detectCharset(b []byte, declared string, converted bool) string {
+	if converted {
+		if declared == "windows-1252" && RepairMojibake(string(b)) != string(b) {
+			return "utf-8"
+		}
+		return declared
+	}
+	switch {
+	case bytes.HasPrefix(b, []byte{0xef, 0xbb, 0xbf}):
+		return "utf-8"
+	case bytes.HasPrefix(b, []byte{0xfe, 0xff}):
+		return "utf-16be"
+	case bytes.HasPrefix(b, []byte{0xff, 0xfe}):
+		return "utf-16le"
+	}
+	if utf8.Valid(b) {
+		if declared != "" {
+			return declared
+		}
+		if isASCII(string(b)) {
+			return "us-ascii"
+		}
+		return "utf-8"
+	}
+	if declared == "" || declared == "utf-8" {
+		// Windows-1252 is a superset of ISO-8859-1, and the most common 8-bit charset
+		return "windows-1252"
+	}
+	return declared
+}
+
+// RepairMojibake detects text that is actually UTF-8 that was misinterpreted as Windows-1252 or
+// ISO-8859-1, for example "CafÃ©" instead of "Café", and returns the reinterpreted text.  This is a
+// heuristic: s is only changed if every character maps back to a single byte, and those bytes form
+// valid UTF-8 containing at least one multi-byte sequence.  Otherwise s is returned unchanged.
+func RepairMojibake(s string) string {
+	if isASCII(s) {
+		return s
+	}
+	b, err := charmap.Windows1252.NewEncoder().Bytes([]byte(s))
+	if err != nil {
+		// A character outside of Windows-1252, this is not mojibake
+		return s
+	}
+	if !utf8.Valid(b) || isASCII(string(b)) {
+		return s
+	}
+	repaired := string(b)
+	if strings.Count(repaired, "\ufffd") > strings.Count(s, "\ufffd") {
+		return s
+	}
+	return repaired
+}
+
+// isASCII returns true if s contains only 7-bit characters.
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
@@ -2,6 +2,8 @@ package enmime
 
 import (
 	"bytes"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"strings"
 	"testing"
@@ -67,3 +69,219 @@ func TestFindCharsetInHTML(t *testing.T) {
 		}
 	}
 }
+
+func TestRepairMojibake(t *testing.T) {
+	testCases := []struct {
+		input, want string
+	}{
+		{"CafÃ©", "Café"},
+		{"crÃ¨me brÃ»lÃ©e", "crème brûlée"},
+		{"Itâ€™s", "It’s"},
+		{"æ—¥æœ¬èªž", "日本語"},
+		// Not mojibake
+		{"Café", "Café"},
+		{"plain ascii", "plain ascii"},
+		{"日本語", "日本語"},
+		{"Â", "Â"},
+		{"", ""},
+	}
+	for _, tc := range testCases {
+		if got := RepairMojibake(tc.input); got != tc.want {
+			t.Errorf("RepairMojibake(%q) == %q, want: %q", tc.input, got, tc.want)
+		}
+	}
+}
+
+func TestSetCharsetReader(t *testing.T) {
+	defer SetCharsetReader(nil)
+	SetCharsetReader(func(charset string, input io.Reader) (io.Reader, error) {
+		if strings.ToLower(charset) == "x-rot13" {
+			return rot13(input), nil
+		}
+		return newCharsetReader(charset, input)
+	})
+
+	if got, want := decodeHeader("=?x-rot13?q?Uryyb?= =?iso-8859-1?q?Caf=E9?="), "HelloCafé"; got != want {
+		t.Errorf("decodeHeader() got: %q, want: %q", got, want)
+	}
+
+	raw := "Content-Type: text/plain; charset=x-rot13\r\n\r\nFrperg\r\n"
+	e, err := ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Secret\r\n"; e.Text != want {
+		t.Errorf("Text got: %q, want: %q", e.Text, want)
+	}
+
+	SetCharsetReader(nil)
+	if got, want := decodeHeader("=?x-rot13?q?Uryyb?="), "=?x-rot13?q?Uryyb?="; got != want {
+		t.Errorf("decodeHeader() after reset got: %q, want: %q", got, want)
+	}
+}
+
+func TestEastAsianCharsets(t *testing.T) {
+	// The final character of the subject is outside of GBK, and requires GB18030
+	subject := "=?GB18030?Q?=D6=D0=CE=C4=D3=CA=BC=FE=95=32=82=36?="
+	if got, want := decodeHeader(subject), "中文邮件\U00020000"; got != want {
+		t.Errorf("decodeHeader(%q) got: %q, want: %q", subject, got, want)
+	}
+
+	sjis := "\x93\xfa\x96\x7b\x8c\xea\x82\xcc\x83\x81\x81\x5b\x83\x8b"
+	for _, charset := range []string{"Shift_JIS", "sjis", "cp932"} {
+		raw := "Content-Type: text/plain; charset=" + charset + "\r\n\r\n" + sjis
+		e, err := ReadEnvelope(strings.NewReader(raw))
+		if err != nil {
+			t.Fatal(charset, err)
+		}
+		if want := "日本語のメール"; e.Text != want {
+			t.Errorf("%s Text got: %q, want: %q", charset, e.Text, want)
+		}
+		if len(e.Errors) != 0 {
+			t.Errorf("%s got unexpected errors: %v", charset, e.Errors)
+		}
+	}
+
+	// An unsupported charset leaves the content as-is, with a warning
+	raw := "Content-Type: text/plain; charset=x-unknown-cjk\r\n\r\n" + sjis
+	e, err := ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Text != sjis {
+		t.Errorf("Text got: %q, want: %q", e.Text, sjis)
+	}
+	if len(e.Errors) != 1 || e.Errors[0].Name != ErrorCharsetConversion {
+		t.Errorf("Got errors %v, want a single %q warning", e.Errors, ErrorCharsetConversion)
+	}
+}
+
+func TestWindowsCharsets(t *testing.T) {
+	testCases := []struct {
+		charset    string
+		input      string
+		want       string
+		unmappable bool
+	}{
+		{"windows-1250", "\x9a", "š", false},
+		{"CP1250", "\x9a", "š", false},
+		{"windows1250", "\x9a", "š", false},
+		{"windows-1250", "a\x81b", "a\ufffdb", true},
+		{"windows-1251", "\xc6", "Ж", false},
+		{"x-cp1251", "\xc6", "Ж", false},
+		{"win-1251", "\xc6", "Ж", false},
+		{"cp-1251", "\xc6", "Ж", false},
+		{"windows-1251", "a\x98b", "a\ufffdb", true},
+		{"windows-1255", "\xf9", "ש", false},
+		{"cp1255", "\xf9", "ש", false},
+		{"Windows_1255", "\xf9", "ש", false},
+		{"windows-1255", "a\xd9b", "a\ufffdb", true},
+	}
+	for _, tc := range testCases {
+		subject := "=?" + tc.charset + "?Q?" + qEncodeBytes(tc.input) + "?="
+		if got := decodeHeader(subject); got != tc.want {
+			t.Errorf("%s: decodeHeader(%q) got: %q, want: %q", tc.charset, subject, got, tc.want)
+		}
+
+		raw := "Content-Type: text/plain; charset=" + tc.charset + "\r\n\r\n" + tc.input
+		e, err := ReadEnvelope(strings.NewReader(raw))
+		if err != nil {
+			t.Fatal(tc.charset, err)
+		}
+		if e.Text != tc.want {
+			t.Errorf("%s: Text got: %q, want: %q", tc.charset, e.Text, tc.want)
+		}
+		warned := len(e.Errors) == 1 && e.Errors[0].Name == ErrorCharsetConversion &&
+			!e.Errors[0].Severe
+		if tc.unmappable && !warned {
+			t.Errorf("%s: got errors %v, want a single %q warning",
+				tc.charset, e.Errors, ErrorCharsetConversion)
+		}
+		if !tc.unmappable && len(e.Errors) != 0 {
+			t.Errorf("%s: got unexpected errors: %v", tc.charset, e.Errors)
+		}
+	}
+}
+
+// qEncodeBytes encodes every byte of s as =XX, for use in a Q encoded-word.
+func qEncodeBytes(s string) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		fmt.Fprintf(&buf, "=%02X", s[i])
+	}
+	return buf.String()
+}
+
+func TestISO2022JP(t *testing.T) {
+	for _, charset := range []string{"ISO-2022-JP", "csISO2022JP", "x-iso-2022-jp", "ISO2022JP"} {
+		subject := "=?" + charset + "?B?GyRCRnxLXDhsJE43b0w+GyhC?="
+		if got, want := decodeHeader(subject), "日本語の件名"; got != want {
+			t.Errorf("decodeHeader(%q) got: %q, want: %q", subject, got, want)
+		}
+
+		raw := "Subject: " + subject + "\r\n" +
+			"Content-Type: text/plain; charset=" + charset + "\r\n" +
+			"Content-Transfer-Encoding: 7bit\r\n" +
+			"\r\n" +
+			"\x1b$B$3$s$K$A$O!\"@$3&\x1b(B\r\n"
+		e, err := ReadEnvelope(strings.NewReader(raw))
+		if err != nil {
+			t.Fatal(charset, err)
+		}
+		if got, want := e.GetHeader("Subject"), "日本語の件名"; got != want {
+			t.Errorf("%s Subject got: %q, want: %q", charset, got, want)
+		}
+		if want := "こんにちは、世界\r\n"; e.Text != want {
+			t.Errorf("%s Text got: %q, want: %q", charset, e.Text, want)
+		}
+		if len(e.Errors) != 0 {
+			t.Errorf("%s got unexpected errors: %v", charset, e.Errors)
+		}
+	}
+}
+
+func TestDetectedCharset(t *testing.T) {
+	testCases := []struct {
+		name, charset, body string
+		want                string
+		warn                bool
+	}{
+		{"BOM without charset", "", "\xef\xbb\xbfCaf\xc3\xa9", "utf-8", false},
+		{"UTF-16 BOM without charset", "", "\xff\xfeC\x00", "utf-16le", false},
+		{"ASCII without charset", "", "Cafe", "us-ascii", false},
+		{"UTF-8 without charset", "", "Caf\xc3\xa9", "utf-8", false},
+		{"Latin-1 declared as UTF-8", "utf-8", "Caf\xe9", "windows-1252", true},
+		{"UTF-8 declared as Latin-1", "iso-8859-1", "Caf\xc3\xa9", "utf-8", true},
+		{"Correct Latin-1", "ISO-8859-1", "Caf\xe9", "windows-1252", false},
+		{"Correct UTF-8", "UTF-8", "Caf\xc3\xa9", "utf-8", false},
+	}
+	for _, tc := range testCases {
+		raw := "Content-Type: text/plain"
+		if tc.charset != "" {
+			raw += "; charset=" + tc.charset
+		}
+		raw += "\r\n\r\n" + tc.body
+		p, err := ReadParts(strings.NewReader(raw))
+		if err != nil {
+			t.Fatalf("%s: Unexpected parse error: %v", tc.name, err)
+		}
+		if got := p.DetectedCharset(); got != tc.want {
+			t.Errorf("%s: DetectedCharset() got: %q, want: %q", tc.name, got, tc.want)
+		}
+		// The warning is recorded while parsing, calls do not record it again
+		p.DetectedCharset()
+		warnings := 0
+		for _, perr := range p.Errors {
+			if perr.Name == ErrorCharsetConversion {
+				warnings++
+			}
+		}
+		want := 0
+		if tc.warn {
+			want = 1
+		}
+		if warnings != want {
+			t.Errorf("%s: Got %v charset warnings, want %v", tc.name, warnings, want)
+		}
+	}
+}
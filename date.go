@@ -0,0 +1,105 @@
+package enmime
+
+import (
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// obsoleteZones maps the named North American time zones permitted by RFC 822 to their offsets.
+// Go's time package parses unknown zone abbreviations as UTC, so these must be repaired before
+// parsing.
+var obsoleteZones = map[string]string{
+	"EST": "-0500",
+	"EDT": "-0400",
+	"CST": "-0600",
+	"CDT": "-0500",
+	"MST": "-0700",
+	"MDT": "-0600",
+	"PST": "-0800",
+	"PDT": "-0700",
+}
+
+// dateLayouts are tried, in order, against a repaired Date header that mail.ParseDate rejected.
+var dateLayouts = []string{
+	"Monday, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 January 2006 15:04:05 -0700",
+	"2 January 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05",
+	"2 Jan 2006 15:04:05",
+	"Mon Jan _2 15:04:05 2006",
+	"Mon Jan _2 15:04:05 -0700 2006",
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02 15:04:05 -0700",
+}
+
+var dateCommentRegexp = regexp.MustCompile(`\([^)]*\)`)
+
+// Date parses the Date header of the message.  Dates that do not conform to RFC 5322 are parsed
+// with a series of fallback layouts after removing comments and replacing obsolete zone names; in
+// that case the time is returned along with a warning: an *Error that is not Severe.  If the date
+// cannot be parsed a Severe *Error is returned, or mail.ErrHeaderNotPresent if there is no Date
+// header.
+func (e *Envelope) Date() (time.Time, error) {
+	value := e.GetHeader("Date")
+	if value == "" {
+		return time.Time{}, mail.ErrHeaderNotPresent
+	}
+	return parseDate(value)
+}
+
+// parseDate implements Date, parsing the provided header value.
+func parseDate(value string) (time.Time, error) {
+	repaired := repairDate(value)
+	if !hasObsoleteZone(value) {
+		if t, err := mail.ParseDate(value); err == nil {
+			return t, nil
+		}
+	}
+
+	t, err := mail.ParseDate(repaired)
+	for _, layout := range dateLayouts {
+		if err == nil {
+			break
+		}
+		t, err = time.Parse(layout, repaired)
+	}
+	if err != nil {
+		return time.Time{}, &Error{
+			Name:   ErrorMalformedHeader,
+			Detail: fmt.Sprintf("Unable to parse Date %q", value),
+			Severe: true,
+			Code:   ErrCodeMalformedHeader,
+		}
+	}
+	return t, &Error{
+		Name:   ErrorMalformedHeader,
+		Detail: fmt.Sprintf("Date %q does not conform to RFC 5322, parsed as %q", value, repaired),
+		Severe: false,
+		Code:   ErrCodeMalformedHeader,
+	}
+}
+
+// repairDate removes comments and redundant whitespace from a Date header value, and replaces
+// obsolete zone names with their numeric offsets.
+func repairDate(value string) string {
+	fields := strings.Fields(dateCommentRegexp.ReplaceAllString(value, " "))
+	for i, f := range fields {
+		if offset, ok := obsoleteZones[strings.ToUpper(f)]; ok {
+			fields[i] = offset
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+// hasObsoleteZone returns true if value, ignoring comments, ends with an obsolete zone name.
+func hasObsoleteZone(value string) bool {
+	fields := strings.Fields(dateCommentRegexp.ReplaceAllString(value, " "))
+	if len(fields) == 0 {
+		return false
+	}
+	_, ok := obsoleteZones[strings.ToUpper(fields[len(fields)-1])]
+	return ok
+}
@@ -0,0 +1,72 @@
+package enmime
+
+import (
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnvelopeDate(t *testing.T) {
+	testCases := []struct {
+		date     string
+		want     string // RFC 3339
+		fallback bool
+	}{
+		{"Mon, 02 Jan 2006 15:04:05 -0700", "2006-01-02T15:04:05-07:00", false},
+		{"Tue, 3 Jan 2017 10:00:00 -0800 (PST)", "2017-01-03T10:00:00-08:00", false},
+		{"3 Jan 2017 10:00:00 -0800", "2017-01-03T10:00:00-08:00", false},
+		{"Tue, 3 Jan 2017 10:00:00 GMT", "2017-01-03T10:00:00Z", false},
+		{"Tue, 3 Jan 2017 10:00:00 PST", "2017-01-03T10:00:00-08:00", true},
+		{"Tue, 3 Jan 2017 10:00:00 EDT (Eastern Daylight)", "2017-01-03T10:00:00-04:00", true},
+		{"Tuesday, 3 Jan 2017 10:00:00 +0100", "2017-01-03T10:00:00+01:00", true},
+		{"Tue Jan  3 10:00:00 2017", "2017-01-03T10:00:00Z", true},
+		{"2017-01-03T10:00:00+02:00", "2017-01-03T10:00:00+02:00", true},
+	}
+	for _, tc := range testCases {
+		e := &Envelope{header: &textproto.MIMEHeader{"Date": []string{tc.date}}}
+		got, err := e.Date()
+		if tc.fallback {
+			perr, ok := err.(*Error)
+			if !ok || perr.Severe {
+				t.Errorf("Date() for %q returned error %v, want a warning", tc.date, err)
+				continue
+			}
+		} else if err != nil {
+			t.Errorf("Date() for %q returned error: %v", tc.date, err)
+			continue
+		}
+		want, _ := time.Parse(time.RFC3339, tc.want)
+		if !got.Equal(want) {
+			t.Errorf("Date() for %q got: %v, want: %v", tc.date, got, want)
+		}
+		_, gotOffset := got.Zone()
+		_, wantOffset := want.Zone()
+		if gotOffset != wantOffset {
+			t.Errorf("Date() for %q got zone offset: %v, want: %v", tc.date, gotOffset, wantOffset)
+		}
+	}
+}
+
+func TestEnvelopeDateInvalid(t *testing.T) {
+	e := &Envelope{header: &textproto.MIMEHeader{"Date": []string{"yesterday"}}}
+	_, err := e.Date()
+	if perr, ok := err.(*Error); !ok || !perr.Severe {
+		t.Errorf("Date() got error %v, want a severe *Error", err)
+	}
+
+	e = &Envelope{header: &textproto.MIMEHeader{}}
+	if _, err := e.Date(); err != mail.ErrHeaderNotPresent {
+		t.Errorf("Date() without header got error %v, want: %v", err, mail.ErrHeaderNotPresent)
+	}
+
+	r := strings.NewReader("Date: Tue, 3 Jan 2017 10:00:00 -0800\r\n\r\nBody\r\n")
+	env, err := ReadEnvelope(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := env.Date(); err != nil || got.Year() != 2017 {
+		t.Errorf("Date() of parsed message got: %v, %v", got, err)
+	}
+}
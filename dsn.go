@@ -0,0 +1,114 @@
+package enmime
+
+import (
+	"bufio"
+	"bytes"
+	"net/textproto"
+	"strings"
+)
+
+// DeliveryStatus holds the content of a delivery status notification, a multipart/report message
+// with a report-type of delivery-status (RFC 3464).
+type DeliveryStatus struct {
+	Part           *Part                // The multipart/report Part
+	Text           string               // The human readable explanation
+	ReportingMTA   string               // The Reporting-MTA field, without its type
+	Recipients     []*RecipientStatus   // The per-recipient fields, in order of appearance
+	OriginalHeader textproto.MIMEHeader // Header of the original message, if it was returned
+}
+
+// RecipientStatus holds the per-recipient fields of a delivery status notification.  Address
+// fields have their address type, such as "rfc822;", removed.
+type RecipientStatus struct {
+	FinalRecipient    string // The Final-Recipient field
+	OriginalRecipient string // The Original-Recipient field
+	Action            string // The Action field, ie "failed" or "delayed"
+	Status            string // The Status field, ie "5.1.1"
+	DiagnosticCode    string // The Diagnostic-Code field, including its type, ie "smtp; 550 ..."
+	RemoteMTA         string // The Remote-MTA field
+}
+
+// DeliveryStatus returns the delivery status notification contained in the message, or nil if the
+// message does not contain one.
+func (e *Envelope) DeliveryStatus() *DeliveryStatus {
+	if e.Root == nil {
+		return nil
+	}
+	report := e.Root.DepthMatchFirst(func(p *Part) bool {
+		return p.ContentType == ctMultipartReport
+	})
+	if report == nil {
+		return nil
+	}
+
+	var status *Part
+	ds := &DeliveryStatus{Part: report}
+	for p := report.FirstChild; p != nil; p = p.NextSibling {
+		switch p.ContentType {
+		case ctTextPlain:
+			if ds.Text == "" {
+				ds.Text = string(p.Content)
+			}
+		case ctMessageDeliveryStatus:
+			status = p
+		case ctMessageRFC822, ctTextRFC822Headers:
+			if content, err := p.readContent(); err == nil {
+				ds.OriginalHeader, _ = readHeader(
+					bufio.NewReader(bytes.NewReader(content)), &Part{}, &Parser{})
+			}
+		}
+	}
+	if status == nil {
+		return nil
+	}
+
+	// The first group of fields is per-message, the remainder are per-recipient
+	content, err := status.readContent()
+	if err != nil {
+		return nil
+	}
+	groups := readFieldGroups(content)
+	if len(groups) > 0 {
+		ds.ReportingMTA = stripFieldType(groups[0].Get("Reporting-MTA"))
+		groups = groups[1:]
+	}
+	for _, g := range groups {
+		ds.Recipients = append(ds.Recipients, &RecipientStatus{
+			FinalRecipient:    stripFieldType(g.Get("Final-Recipient")),
+			OriginalRecipient: stripFieldType(g.Get("Original-Recipient")),
+			Action:            g.Get("Action"),
+			Status:            g.Get("Status"),
+			DiagnosticCode:    g.Get("Diagnostic-Code"),
+			RemoteMTA:         stripFieldType(g.Get("Remote-MTA")),
+		})
+	}
+	return ds
+}
+
+// readFieldGroups parses the blank line separated groups of header style fields in b, as used by
+// message/delivery-status content.  Empty groups are skipped.
+func readFieldGroups(b []byte) []textproto.MIMEHeader {
+	var groups []textproto.MIMEHeader
+	r := bufio.NewReader(bytes.NewReader(b))
+	for {
+		if _, err := r.Peek(1); err != nil {
+			return groups
+		}
+		header, err := readHeader(r, &Part{}, &Parser{})
+		if err != nil {
+			return groups
+		}
+		if len(header) > 0 {
+			groups = append(groups, header)
+		}
+	}
+}
+
+// stripFieldType removes the type prefix from a delivery status field value, ie the "rfc822;" in
+// "rfc822; user@example.com".
+func stripFieldType(value string) string {
+	if i := strings.IndexByte(value, ';'); i != -1 {
+		return strings.TrimSpace(value[i+1:])
+	}
+	return value
+}
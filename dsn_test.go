@@ -0,0 +1,57 @@
+package enmime
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEnvelopeDeliveryStatus(t *testing.T) {
+	e, err := ReadEnvelope(openTestData("mail", "dsn.raw"))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+
+	ds := e.DeliveryStatus()
+	if ds == nil {
+		t.Fatal("DeliveryStatus() == nil, want a DeliveryStatus")
+	}
+	if !strings.Contains(ds.Text, "could not\r\nbe delivered") {
+		t.Errorf("Text == %q, should contain the explanation", ds.Text)
+	}
+	if got, want := ds.ReportingMTA, "mx.example.com"; got != want {
+		t.Errorf("ReportingMTA == %q, want: %q", got, want)
+	}
+	if len(ds.Recipients) != 1 {
+		t.Fatalf("Got %v recipients, want 1", len(ds.Recipients))
+	}
+
+	r := ds.Recipients[0]
+	want := RecipientStatus{
+		FinalRecipient:    "nobody@example.com",
+		OriginalRecipient: "nobody@example.com",
+		Action:            "failed",
+		Status:            "5.1.1",
+		DiagnosticCode:    "smtp; 550 5.1.1 <nobody@example.com>: Recipient address rejected: User unknown",
+		RemoteMTA:         "mx.example.com",
+	}
+	if *r != want {
+		t.Errorf("Recipient\ngot : %+v\nwant: %+v", *r, want)
+	}
+
+	if got, want := ds.OriginalHeader.Get("Subject"), "Hello there"; got != want {
+		t.Errorf("OriginalHeader Subject == %q, want: %q", got, want)
+	}
+	if got, want := ds.OriginalHeader.Get("Message-Id"), "<original@example.org>"; got != want {
+		t.Errorf("OriginalHeader Message-Id == %q, want: %q", got, want)
+	}
+}
+
+func TestEnvelopeDeliveryStatusNone(t *testing.T) {
+	e, err := ReadEnvelope(openTestData("mail", "html-mime-inline.raw"))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+	if ds := e.DeliveryStatus(); ds != nil {
+		t.Errorf("DeliveryStatus() == %+v, want nil", ds)
+	}
+}
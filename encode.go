@@ -0,0 +1,425 @@
+package enmime
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net/textproto"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const (
+	// base64LineLen is the maximum length of a base64 encoded line, per RFC 2045
+	base64LineLen = 76
+	// maxHeaderLineLen is the length header lines should be folded at, per RFC 5322
+	maxHeaderLineLen = 78
+	// maxBodyLineLen is the maximum length of a line of 7bit content, per RFC 5322
+	maxBodyLineLen = 998
+)
+
+// structuredHeaders is the set of headers containing semicolon separated parameters, folded after
+// semicolons by foldHeaderLine.  Keys must be in canonical form.
+var structuredHeaders = map[string]bool{
+	hnContentType:        true,
+	hnContentDisposition: true,
+}
+
+// Encode serializes the Envelope back into RFC 822 format, writing the result to w.  The Part tree
+// is walked, re-emitting headers and bodies while preserving the multipart structure.  Leaf
+// content is re-encoded from Part.Content using the part's Content-Transfer-Encoding; content that
+// was converted to UTF-8 while parsing is declared as UTF-8 in the output.  Header fields that
+// have not been modified are written verbatim and in their original order.  A multipart boundary
+// that is missing, or appears within the encoded content of the part, is replaced with a new
+// random boundary.  The format parameter is removed from text that was rejoined from
+// format=flowed.  Content containing 8-bit data that is declared as 7bit, or has no
+// Content-Transfer-Encoding, is re-encoded as it would be by SetContent.  For a message with a
+// binary-only body the parsed root Part is encoded, rather than the placeholder Root.  The output
+// will not be byte-identical to the input, but should parse to an equivalent Envelope.
+func (e *Envelope) Encode(w io.Writer) error {
+	root := e.messageRoot()
+	if root == nil {
+		return errors.New("envelope has no Root Part to encode")
+	}
+	b := bufio.NewWriter(w)
+	if err := root.encode(b); err != nil {
+		return err
+	}
+	return b.Flush()
+}
+
+// encode writes the headers and body of p, followed by any children, to b.
+func (p *Part) encode(b *bufio.Writer) error {
+	header := make(textproto.MIMEHeader, len(p.Header))
+	for k, v := range p.Header {
+		header[k] = v
+	}
+	mediatype, params, err := parseMediaType(header.Get(hnContentType))
+	multipart := err == nil && strings.HasPrefix(mediatype, ctMultipartPrefix)
+
+	// Children are encoded first, so that a boundary which does not appear within them may be
+	// chosen
+	var children [][]byte
+	if multipart {
+		for c := p.FirstChild; c != nil; c = c.NextSibling {
+			buf := new(bytes.Buffer)
+			cb := bufio.NewWriter(buf)
+			if err := c.encode(cb); err != nil {
+				return err
+			}
+			if err := cb.Flush(); err != nil {
+				return err
+			}
+			children = append(children, buf.Bytes())
+		}
+	}
+
+	if err == nil {
+		rewrite := false
+		if multipart && (params[hpBoundary] == "" ||
+			boundaryCollides(params[hpBoundary], children)) {
+			boundary, err := uniqueBoundary(children)
+			if err != nil {
+				return err
+			}
+			params[hpBoundary] = boundary
+			rewrite = true
+		}
+		if p.converted && params[hpCharset] != "" {
+			params[hpCharset] = "utf-8"
+			rewrite = true
+		}
+		if p.deflowed {
+			// Content is no longer flowed
+			delete(params, hpFormat)
+			delete(params, hpDelSp)
+			rewrite = true
+		}
+		if rewrite {
+			header[hnContentType] = []string{mime.FormatMediaType(mediatype, params)}
+		}
+	}
+
+	if !multipart {
+		encoding := p.transferEncoding(mediatype)
+		if !strings.EqualFold(encoding, header.Get(hnContentEncoding)) {
+			header.Set(hnContentEncoding, encoding)
+		}
+		p.encodeHeader(b, header)
+		return p.encodeContent(b, encoding)
+	}
+	p.encodeHeader(b, header)
+
+	// Children
+	boundary := params[hpBoundary]
+	for _, child := range children {
+		b.WriteString("--")
+		b.WriteString(boundary)
+		b.WriteString("\r\n")
+		b.Write(child)
+		b.WriteString("\r\n")
+	}
+	b.WriteString("--")
+	b.WriteString(boundary)
+	_, err = b.WriteString("--\r\n")
+	return err
+}
+
+// encodeHeader writes header to b.  Fields that are unchanged since parsing are written using
+// their original lines, in their original order; modified fields are re-encoded in place of their
+// first occurrence, and any new fields follow in sorted order.
+func (p *Part) encodeHeader(b *bufio.Writer, header textproto.MIMEHeader) {
+	written := make(map[string]bool)
+	for _, f := range p.rawHeader {
+		if written[f.name] {
+			continue
+		}
+		if reflect.DeepEqual(header[f.name], p.origHeader[f.name]) {
+			b.Write(f.raw)
+			continue
+		}
+		written[f.name] = true
+		writeHeaderField(b, f.name, header[f.name])
+	}
+
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		if _, ok := p.origHeader[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		writeHeaderField(b, k, header[k])
+	}
+	b.WriteString("\r\n")
+}
+
+// writeHeaderField writes a "Name: value" field to b for each of values, folding long values.
+func writeHeaderField(b *bufio.Writer, name string, values []string) {
+	for _, v := range values {
+		for _, line := range foldHeaderLine(name, v) {
+			b.WriteString(line)
+			b.WriteString("\r\n")
+		}
+	}
+}
+
+// foldHeaderLine formats a "Name: value" header field, folding it into multiple lines where it
+// would exceed maxHeaderLineLen.  Continuation lines begin with the whitespace the fold was made
+// at.  Structured headers such as Content-Type are folded at whitespace following a semicolon,
+// address headers at whitespace following a comma, and other headers at any whitespace.  Folds
+// are never made inside a quoted string; encoded-words contain no whitespace, so are never split.
+// A segment that cannot be folded is left to exceed the limit.
+func foldHeaderLine(name, value string) []string {
+	line := name + ": " + value
+	if len(line) <= maxHeaderLineLen {
+		return []string{line}
+	}
+
+	// Only fold after this separator, if set
+	var sep byte
+	switch {
+	case structuredHeaders[textproto.CanonicalMIMEHeaderKey(name)]:
+		sep = ';'
+	case AddressHeaders[strings.ToLower(name)]:
+		sep = ','
+	}
+
+	// Split value into segments at permitted folding points
+	var segments []string
+	start := 0
+	inQuote := false
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case inQuote:
+			if c == '\\' {
+				i++
+			} else if c == '"' {
+				inQuote = false
+			}
+		case c == '"':
+			inQuote = true
+		case c == ' ' || c == '\t':
+			if i == 0 {
+				// A fold here would leave the first line without a value
+				continue
+			}
+			prev := value[i-1]
+			if prev == ' ' || prev == '\t' {
+				// Fold at the start of a whitespace run only
+				continue
+			}
+			if sep == 0 || prev == sep {
+				segments = append(segments, value[start:i])
+				start = i
+			}
+		}
+	}
+	segments = append(segments, value[start:])
+
+	// Pack as many segments into each line as will fit
+	var lines []string
+	line = name + ": " + segments[0]
+	for _, seg := range segments[1:] {
+		if len(line)+len(seg) > maxHeaderLineLen {
+			lines = append(lines, line)
+			line = seg
+		} else {
+			line += seg
+		}
+	}
+	return append(lines, line)
+}
+
+// SetContent replaces the content of p with data, which must not be encoded, and sets its
+// Content-Type to contentType, which may include parameters such as charset.  The
+// Content-Transfer-Encoding header is updated to suit data: 7bit for text that is ASCII with
+// short lines, quoted-printable for other text, and base64 for anything else.  Encode will then
+// write the new content.  The temporary file holding the previous content, if any, is removed.
+func (p *Part) SetContent(data []byte, contentType string) {
+	if p.Header == nil {
+		p.Header = make(textproto.MIMEHeader)
+	}
+	mediatype, params, err := parseMediaType(contentType)
+	if err != nil {
+		mediatype, params = contentType, nil
+	}
+	p.ContentType = mediatype
+	p.Charset = params[hpCharset]
+	p.ctypeParams = params
+	p.Header.Set(hnContentType, contentType)
+	p.Header.Set(hnContentEncoding, chooseTransferEncoding(mediatype, data))
+
+	if p.TempPath != "" {
+		_ = os.Remove(p.TempPath)
+		p.TempPath = ""
+	}
+	p.Content = data
+	p.converted = false
+	p.deflowed = false
+	p.rawReader = nil
+	p.decodedReader = nil
+	p.utf8Reader = bytes.NewReader(data)
+}
+
+// chooseTransferEncoding returns the Content-Transfer-Encoding SetContent uses for data of the
+// given media type.
+func chooseTransferEncoding(mediatype string, data []byte) string {
+	if !strings.HasPrefix(mediatype, ctTextPrefix) {
+		return "base64"
+	}
+	if is7bit(data) {
+		return "7bit"
+	}
+	return "quoted-printable"
+}
+
+// is7bit returns true if data is valid 7bit content per RFC 2045: ASCII without NUL or bare CR,
+// in lines of no more than maxBodyLineLen bytes.
+func is7bit(data []byte) bool {
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		if len(line) > maxBodyLineLen || bytes.IndexByte(line, '\r') != -1 ||
+			bytes.IndexByte(line, 0) != -1 {
+			return false
+		}
+		for _, c := range line {
+			if c >= 0x80 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// transferEncoding returns the lowercase Content-Transfer-Encoding Encode writes the content of p
+// with.  The declared encoding is used, unless it is 7bit or absent and the content is not valid
+// 7bit data, which would be corrupted in transit; SetContent's choice is used instead.
+func (p *Part) transferEncoding(mediatype string) string {
+	encoding := strings.ToLower(p.Header.Get(hnContentEncoding))
+	if encoding != "" && encoding != "7bit" {
+		return encoding
+	}
+	content, err := p.readContent()
+	if err != nil || is7bit(content) {
+		// An unreadable content error is reported by encodeContent
+		return encoding
+	}
+	if mediatype == "" {
+		// The default Content-Type, per RFC 2045
+		mediatype = ctTextPlain
+	}
+	return chooseTransferEncoding(mediatype, content)
+}
+
+// encodeContent writes the decoded content of p to b using the provided Content-Transfer-Encoding.
+func (p *Part) encodeContent(b *bufio.Writer, encoding string) error {
+	r, err := p.contentReader()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	switch encoding {
+	case "base64":
+		enc := base64.NewEncoder(base64.StdEncoding, &lineWrapper{w: b, max: base64LineLen})
+		if _, err := io.Copy(enc, r); err != nil {
+			return err
+		}
+		return enc.Close()
+	case "quoted-printable":
+		qp := quotedprintable.NewWriter(b)
+		if _, err := io.Copy(qp, r); err != nil {
+			return err
+		}
+		return qp.Close()
+	default:
+		_, err = io.Copy(b, r)
+		return err
+	}
+}
+
+// lineWrapper writes to w, inserting a CRLF after every max bytes written except at the end.
+type lineWrapper struct {
+	w   io.Writer
+	max int
+	n   int // Bytes written to the current line
+}
+
+// Write method for io.Writer interface.
+func (l *lineWrapper) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		if l.n == l.max {
+			if _, err := io.WriteString(l.w, "\r\n"); err != nil {
+				return written, err
+			}
+			l.n = 0
+		}
+		chunk := b
+		if len(chunk) > l.max-l.n {
+			chunk = chunk[:l.max-l.n]
+		}
+		n, err := l.w.Write(chunk)
+		written += n
+		l.n += n
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// maxBoundaryAttempts limits the number of boundaries uniqueBoundary will generate.
+const maxBoundaryAttempts = 10
+
+// newBoundary generates multipart boundary markers for Encode, it may be replaced by tests.
+var newBoundary = randomBoundary
+
+// uniqueBoundary returns a new boundary marker that does not appear within any of the encoded
+// children.  With the entropy of randomBoundary a collision is very unlikely, but content that
+// contained the boundary would corrupt the output.
+func uniqueBoundary(children [][]byte) (string, error) {
+	for i := 0; i < maxBoundaryAttempts; i++ {
+		boundary, err := newBoundary()
+		if err != nil {
+			return "", err
+		}
+		if !boundaryCollides(boundary, children) {
+			return boundary, nil
+		}
+	}
+	return "", fmt.Errorf("unable to generate a boundary not found in content after %v attempts",
+		maxBoundaryAttempts)
+}
+
+// boundaryCollides returns true if boundary appears within any of the encoded children, where it
+// could be mistaken for a delimiter.
+func boundaryCollides(boundary string, children [][]byte) bool {
+	for _, child := range children {
+		if bytes.Contains(child, []byte("--"+boundary)) {
+			return true
+		}
+	}
+	return false
+}
+
+// randomBoundary returns a new random multipart boundary marker.
+func randomBoundary() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := io.ReadFull(rand.Reader, buf); err != nil {
+		return "", err
+	}
+	return "enmime-" + hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,485 @@
+package enmime
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEnvelopeEncodeRoundTrip(t *testing.T) {
+	var files = []string{
+		"html-mime-inline.raw",
+		"quoted-printable-mime.raw",
+	}
+
+	for _, file := range files {
+		r := openTestData("mail", file)
+		e, err := ReadEnvelope(r)
+		if err != nil {
+			t.Fatal(file, err)
+		}
+
+		buf := new(bytes.Buffer)
+		if err := e.Encode(buf); err != nil {
+			t.Fatal(file, err)
+		}
+		got, err := ReadEnvelope(buf)
+		if err != nil {
+			t.Fatal(file, err)
+		}
+
+		if got.Text != e.Text {
+			t.Errorf("%s: Text\ngot : %q\nwant: %q", file, got.Text, e.Text)
+		}
+		if got.HTML != e.HTML {
+			t.Errorf("%s: HTML\ngot : %q\nwant: %q", file, got.HTML, e.HTML)
+		}
+		if got.GetHeader("Subject") != e.GetHeader("Subject") {
+			t.Errorf("%s: Subject\ngot : %q\nwant: %q", file, got.GetHeader("Subject"),
+				e.GetHeader("Subject"))
+		}
+		if len(got.Inlines) != len(e.Inlines) {
+			t.Fatalf("%s: got %v inlines, want %v", file, len(got.Inlines), len(e.Inlines))
+		}
+		for i := range e.Inlines {
+			if !bytes.Equal(got.Inlines[i].Content, e.Inlines[i].Content) {
+				t.Errorf("%s: inline %v content did not survive round trip", file, i)
+			}
+		}
+	}
+}
+
+func TestEnvelopeEncodeConvertedCharset(t *testing.T) {
+	raw := "From: alice@example.com\r\n" +
+		"Content-Type: text/plain; charset=iso-8859-1\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"Caf=E9\r\n"
+	e, err := ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := e.Encode(buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "charset=utf-8") {
+		t.Errorf("Encoded output should declare utf-8 charset, got:\n%s", buf.String())
+	}
+	got, err := ReadEnvelope(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Text != e.Text {
+		t.Errorf("Text got: %q, want: %q", got.Text, e.Text)
+	}
+}
+
+func TestEnvelopeEncodePreservesHeaders(t *testing.T) {
+	raw := "Received: from mx.example.com\r\n" +
+		"\tby mail.example.net; Mon, 2 Jan 2017 10:00:00 -0800\r\n" +
+		"Received: from localhost by mx.example.com\r\n" +
+		"X-Custom-Header:   odd  spacing\r\n" +
+		"From: alice@example.com\r\n" +
+		"Subject: Original\r\n" +
+		"X-Mailer: test\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		"Body\r\n"
+	e, err := ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Root.Header.Set("Subject", "Modified")
+	e.Root.Header.Del("X-Mailer")
+	e.Root.Header.Set("X-Added", "new")
+
+	buf := new(bytes.Buffer)
+	if err := e.Encode(buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "Received: from mx.example.com\r\n" +
+		"\tby mail.example.net; Mon, 2 Jan 2017 10:00:00 -0800\r\n" +
+		"Received: from localhost by mx.example.com\r\n" +
+		"X-Custom-Header:   odd  spacing\r\n" +
+		"From: alice@example.com\r\n" +
+		"Subject: Modified\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"X-Added: new\r\n" +
+		"\r\n" +
+		"Body\r\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Encoded message\ngot : %q\nwant: %q", got, want)
+	}
+}
+
+func TestFoldHeaderLine(t *testing.T) {
+	testCases := []struct {
+		name, value string
+		want        []string
+	}{
+		{
+			"Subject",
+			"short",
+			[]string{"Subject: short"},
+		},
+		{
+			"Subject",
+			"This is a rather long subject line that will need to be folded at whitespace somewhere",
+			[]string{
+				"Subject: This is a rather long subject line that will need to be folded at",
+				" whitespace somewhere",
+			},
+		},
+		{
+			"Content-Type",
+			`text/plain; charset=utf-8; format=flowed; name="a long file name with spaces.txt"`,
+			[]string{
+				"Content-Type: text/plain; charset=utf-8; format=flowed;",
+				` name="a long file name with spaces.txt"`,
+			},
+		},
+		{
+			"To",
+			"Alice Example <alice@example.com>, Bob Example <bob@example.com>, carol@example.com",
+			[]string{
+				"To: Alice Example <alice@example.com>, Bob Example <bob@example.com>,",
+				" carol@example.com",
+			},
+		},
+		{
+			// Encoded-words and quoted strings are not split
+			"Subject",
+			"=?UTF-8?Q?A_very_long_encoded_word_that_must_not_be_split_across_lines?= " +
+				`"quoted string with spaces"`,
+			[]string{
+				"Subject: =?UTF-8?Q?A_very_long_encoded_word_that_must_not_be_split_across_lines?=",
+				` "quoted string with spaces"`,
+			},
+		},
+		{
+			// A leading quoted display name is not split at its commas
+			"From",
+			`"Doe, Jonathan, Senior Director of Engineering, Operations and Support" ` +
+				"<jonathan@example.com>, bob@example.com",
+			[]string{
+				`From: "Doe, Jonathan, Senior Director of Engineering, Operations and Support"` +
+					" <jonathan@example.com>,",
+				" bob@example.com",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		got := foldHeaderLine(tc.name, tc.value)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("foldHeaderLine(%q, %q)\ngot : %q\nwant: %q", tc.name, tc.value, got, tc.want)
+		}
+		for _, line := range got[1:] {
+			if line[0] != ' ' && line[0] != '\t' {
+				t.Errorf("Continuation line %q does not begin with whitespace", line)
+			}
+		}
+		if unfolded := strings.Join(got, ""); unfolded != tc.name+": "+tc.value {
+			t.Errorf("Unfolded header %q, want: %q", unfolded, tc.name+": "+tc.value)
+		}
+	}
+}
+
+func TestEnvelopeEncodeNoRoot(t *testing.T) {
+	if err := (&Envelope{}).Encode(new(bytes.Buffer)); err == nil {
+		t.Error("Encode() of an Envelope without Root returned nil, want error")
+	}
+}
+
+func TestEnvelopeEncodeBinaryOnly(t *testing.T) {
+	raw := "From: alice@example.com\r\n" +
+		"Content-Type: application/pdf\r\n" +
+		"Content-Disposition: attachment; filename=report.pdf\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		"JVBERi0xLjQK\r\n"
+	e, err := ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := e.Encode(buf); err != nil {
+		t.Fatal("Encode() error:", err)
+	}
+	got, err := ReadEnvelope(buf)
+	if err != nil {
+		t.Fatal("Failed to parse encoded MIME:", err)
+	}
+	if got.GetHeader("From") != "alice@example.com" {
+		t.Errorf("From got: %q, want: %q", got.GetHeader("From"), "alice@example.com")
+	}
+	if len(got.Attachments) != 1 {
+		t.Fatalf("Got %v attachments after encoding, want 1", len(got.Attachments))
+	}
+	a := got.Attachments[0]
+	if a.FileName != "report.pdf" || string(a.Content) != "%PDF-1.4\n" {
+		t.Errorf("Attachment got: %q %q, want: %q %q", a.FileName, a.Content, "report.pdf",
+			"%PDF-1.4\n")
+	}
+}
+
+func TestEnvelopeEncode8bitContent(t *testing.T) {
+	testCases := []struct {
+		name, header, wantEncoding string
+	}{
+		{"no encoding", "Content-Type: text/plain; charset=utf-8\r\n", "quoted-printable"},
+		{"7bit", "Content-Type: text/plain; charset=utf-8\r\n" +
+			"Content-Transfer-Encoding: 7BIT\r\n", "quoted-printable"},
+		{"8bit", "Content-Type: text/plain; charset=utf-8\r\n" +
+			"Content-Transfer-Encoding: 8bit\r\n", "8bit"},
+		{"binary type", "Content-Type: application/octet-stream\r\n", "base64"},
+	}
+	for _, tc := range testCases {
+		raw := "From: alice@example.com\r\n" + tc.header + "\r\nCaf\xc3\xa9\r\n"
+		p, err := ReadParts(strings.NewReader(raw))
+		if err != nil {
+			t.Fatal(tc.name, err)
+		}
+		buf := new(bytes.Buffer)
+		if err := (&Envelope{Root: p}).Encode(buf); err != nil {
+			t.Fatal(tc.name, err)
+		}
+		got, err := ReadParts(buf)
+		if err != nil {
+			t.Fatal(tc.name, err)
+		}
+		if cte := got.Header.Get(hnContentEncoding); cte != tc.wantEncoding {
+			t.Errorf("%s: Content-Transfer-Encoding got: %q, want: %q", tc.name, cte,
+				tc.wantEncoding)
+		}
+		if !bytes.Equal(got.Content, p.Content) {
+			t.Errorf("%s: Content got: %q, want: %q", tc.name, got.Content, p.Content)
+		}
+	}
+}
+
+func TestEnvelopeEncodeBoundaryCollision(t *testing.T) {
+	raw := "From: alice@example.com\r\n" +
+		"Content-Type: multipart/mixed; boundary=A\r\n" +
+		"\r\n" +
+		"--A\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"Use the --A flag\r\n" +
+		"--A--\r\n"
+	e, err := ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+
+	// Generate boundaries from a tiny alphabet, so that collisions are certain
+	defer func() { newBoundary = randomBoundary }()
+	var generated []string
+	newBoundary = func() (string, error) {
+		b := string("AAC"[len(generated)%3])
+		generated = append(generated, b)
+		return b, nil
+	}
+
+	buf := new(bytes.Buffer)
+	if err := e.Encode(buf); err != nil {
+		t.Fatal("Encode() error:", err)
+	}
+	if strings.Join(generated, "") != "AAC" {
+		t.Errorf("Generated boundaries: %q, want: %q", generated, []string{"A", "A", "C"})
+	}
+	if !strings.Contains(buf.String(), "boundary=C") {
+		t.Errorf("Encoded output does not use the regenerated boundary:\n%s", buf.String())
+	}
+	got, err := ReadEnvelope(buf)
+	if err != nil {
+		t.Fatal("Failed to parse encoded MIME:", err)
+	}
+	if got.Text != e.Text {
+		t.Errorf("Text got: %q, want: %q", got.Text, e.Text)
+	}
+
+	// Give up if every boundary collides
+	newBoundary = func() (string, error) { return "A", nil }
+	if err := e.Encode(new(bytes.Buffer)); err == nil {
+		t.Error("Encode() with only colliding boundaries returned nil, want error")
+	}
+
+	// Failure to generate a boundary is returned
+	newBoundary = func() (string, error) { return "", errors.New("no entropy") }
+	if err := e.Encode(new(bytes.Buffer)); err == nil || err.Error() != "no entropy" {
+		t.Errorf("Encode() with failing boundary generator got: %v, want: no entropy", err)
+	}
+}
+
+func TestEnvelopeEncodeQuotedPrintableLines(t *testing.T) {
+	raw := "From: alice@example.com\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"placeholder\r\n"
+	e, err := ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+	content := strings.Repeat("Crème brûlée, café, naïve — ", 12) + "\r\n" +
+		strings.Repeat("€", 60) + "\r\n"
+	e.Root.Content = []byte(content)
+
+	buf := new(bytes.Buffer)
+	if err := e.Encode(buf); err != nil {
+		t.Fatal("Encode() error:", err)
+	}
+	encoded := buf.String()
+	body := encoded[strings.Index(encoded, "\r\n\r\n")+4:]
+	for i, line := range strings.Split(strings.TrimSuffix(body, "\r\n"), "\r\n") {
+		if len(line) > 76 {
+			t.Errorf("Line %v is %v characters long: %q", i, len(line), line)
+		}
+		for j := strings.IndexByte(line, '='); j != -1; j = strings.IndexByte(line, '=') {
+			rest := line[j+1:]
+			if rest != "" && (len(rest) < 2 || !isValidHexBytes([]byte(rest[:2]))) {
+				t.Errorf("Line %v contains a split or invalid escape: %q", i, line)
+				break
+			}
+			if rest == "" {
+				break
+			}
+			line = rest[2:]
+		}
+	}
+
+	got, err := ReadEnvelope(strings.NewReader(encoded))
+	if err != nil {
+		t.Fatal("Failed to parse encoded MIME:", err)
+	}
+	if got.Text != content {
+		t.Errorf("Text did not survive round trip\ngot : %q\nwant: %q", got.Text, content)
+	}
+}
+
+func TestPartSetContent(t *testing.T) {
+	e, err := ReadEnvelope(openTestData("mail", "attachment.raw"))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+	if len(e.Attachments) != 1 {
+		t.Fatalf("Got %v attachments, want 1", len(e.Attachments))
+	}
+	notice := "The attachment test.html was removed.\r\n"
+	e.Attachments[0].SetContent([]byte(notice), "text/plain; charset=us-ascii")
+
+	buf := new(bytes.Buffer)
+	if err := e.Encode(buf); err != nil {
+		t.Fatal("Encode() error:", err)
+	}
+	got, err := ReadEnvelope(buf)
+	if err != nil {
+		t.Fatal("Failed to parse encoded MIME:", err)
+	}
+	if got.Text != e.Text {
+		t.Errorf("Text got: %q, want: %q", got.Text, e.Text)
+	}
+	if len(got.Attachments) != 1 {
+		t.Fatalf("Got %v attachments after encoding, want 1", len(got.Attachments))
+	}
+	a := got.Attachments[0]
+	if string(a.Content) != notice {
+		t.Errorf("Attachment Content got: %q, want: %q", a.Content, notice)
+	}
+	if a.ContentType != "text/plain" || a.Charset != "us-ascii" {
+		t.Errorf("Attachment type got: %q %q, want: %q %q", a.ContentType, a.Charset,
+			"text/plain", "us-ascii")
+	}
+	if cte := a.Header.Get(hnContentEncoding); cte != "7bit" {
+		t.Errorf("Attachment Content-Transfer-Encoding got: %q, want: %q", cte, "7bit")
+	}
+	if a.FileName != "test.html" {
+		t.Errorf("Attachment FileName got: %q, want: %q", a.FileName, "test.html")
+	}
+
+	// Binary content is base64 encoded
+	data := []byte("\x89PNG\r\n\x1a\n\x00\x00")
+	e.Attachments[0].SetContent(data, "image/png")
+	buf.Reset()
+	if err := e.Encode(buf); err != nil {
+		t.Fatal("Encode() error:", err)
+	}
+	if got, err = ReadEnvelope(buf); err != nil {
+		t.Fatal("Failed to parse encoded MIME:", err)
+	}
+	a = got.Attachments[0]
+	if !bytes.Equal(a.Content, data) || a.Header.Get(hnContentEncoding) != "base64" {
+		t.Errorf("Attachment got: %q encoded as %q, want: %q as base64", a.Content,
+			a.Header.Get(hnContentEncoding), data)
+	}
+}
+
+func TestPartSetContentSinglePart(t *testing.T) {
+	raw := "From: alice@example.com\r\n" +
+		"Content-Type: application/octet-stream\r\n" +
+		"Content-Disposition: attachment; filename=data.bin\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("0123456789abcdef"), 4)) + "\r\n"
+	e, err := (&Parser{InMemoryThreshold: 16}).ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+	defer e.Cleanup()
+	if len(e.Attachments) != 1 || e.Attachments[0].TempPath == "" {
+		t.Fatalf("Got %v attachments, want 1 spilled attachment", len(e.Attachments))
+	}
+	path := e.Attachments[0].TempPath
+	data := []byte("\x89PNG\r\n\x1a\n\x00\x00")
+	e.Attachments[0].SetContent(data, "image/png")
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Temporary file %q still exists after SetContent(), err: %v", path, err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := e.Encode(buf); err != nil {
+		t.Fatal("Encode() error:", err)
+	}
+	got, err := ReadEnvelope(buf)
+	if err != nil {
+		t.Fatal("Failed to parse encoded MIME:", err)
+	}
+	if len(got.Attachments) != 1 {
+		t.Fatalf("Got %v attachments after encoding, want 1", len(got.Attachments))
+	}
+	a := got.Attachments[0]
+	if !bytes.Equal(a.Content, data) || a.ContentType != "image/png" {
+		t.Errorf("Attachment got: %q %q, want: %q %q", a.ContentType, a.Content, "image/png", data)
+	}
+	if a.FileName != "data.bin" {
+		t.Errorf("Attachment FileName got: %q, want: %q", a.FileName, "data.bin")
+	}
+}
+
+func TestChooseTransferEncoding(t *testing.T) {
+	testCases := []struct {
+		mediatype, data, want string
+	}{
+		{"text/plain", "Hello\r\nWorld\r\n", "7bit"},
+		{"text/html", "<p>Café</p>", "quoted-printable"},
+		{"text/plain", strings.Repeat("a", 999), "quoted-printable"},
+		{"text/plain", "bare\rreturn", "quoted-printable"},
+		{"text/plain", "nul\x00", "quoted-printable"},
+		{"application/pdf", "%PDF-1.4", "base64"},
+		{"image/png", "\x89PNG", "base64"},
+	}
+	for _, tc := range testCases {
+		got := chooseTransferEncoding(tc.mediatype, []byte(tc.data))
+		if got != tc.want {
+			t.Errorf("chooseTransferEncoding(%q, %.20q) got: %q, want: %q", tc.mediatype, tc.data,
+				got, tc.want)
+		}
+	}
+}
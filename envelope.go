@@ -1,11 +1,17 @@
 package enmime
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/mail"
 	"net/textproto"
+	"path"
+	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/jaytaylor/html2text"
@@ -20,7 +26,22 @@ type Envelope struct {
 	Inlines     []*Part               // All parts having a Content-Disposition of inline
 	OtherParts  []*Part               // All parts not in Attachments and Inlines
 	Errors      []*Error              // Errors encountered while parsing
+	Stats       ParseStats            // Counters describing the parsing of the message
 	header      *textproto.MIMEHeader // Header from original message
+	contentIDs  map[string]*Part      // Cached result of ContentIDMap
+	parsedRoot  *Part                 // Parsed root when Root is a binary-only body placeholder
+	opts        *Parser               // Options the message was parsed with, if any
+	embedded    []*Envelope           // Parsed message/rfc822 parts, see AllAttachments
+}
+
+// messageRoot returns the root of the Part tree the message was parsed into.  For a message with a
+// binary-only body Root is an empty placeholder, and the parsed root is found in Attachments or
+// Inlines instead.
+func (e *Envelope) messageRoot() *Part {
+	if e.parsedRoot != nil {
+		return e.parsedRoot
+	}
+	return e.Root
 }
 
 // GetHeader processes the specified header for RFC 2047 encoded words and returns the result as a
@@ -29,57 +50,535 @@ func (e *Envelope) GetHeader(name string) string {
 	if e.header == nil {
 		return ""
 	}
-	return decodeHeader(e.header.Get(name))
+	return e.decodeHeader(e.header.Get(name))
+}
+
+// decodeHeader decodes a header value of the message, using the Parser.RawHeaderCharset it was
+// parsed with.
+func (e *Envelope) decodeHeader(value string) string {
+	if e.opts == nil {
+		return decodeHeader(value)
+	}
+	return decodeHeaderCharset(value, e.opts.RawHeaderCharset)
+}
+
+// GetHeaderValues returns all values of the specified header in the order they appeared in the
+// message, with RFC 2047 encoded words converted to UTF-8.  Useful for headers which may repeat,
+// such as Received.
+func (e *Envelope) GetHeaderValues(name string) []string {
+	if e.header == nil {
+		return nil
+	}
+	var values []string
+	for _, v := range (*e.header)[textproto.CanonicalMIMEHeaderKey(name)] {
+		values = append(values, e.decodeHeader(v))
+	}
+	return values
 }
 
-// AddressList returns a mail.Address slice with RFC 2047 encoded names converted to UTF-8
+// AddressList returns a mail.Address slice with RFC 2047 encoded names converted to UTF-8.  Lists
+// rejected by net/mail are parsed again with comments and empty groups removed; a list of only
+// empty groups yields no addresses.  A warning is recorded in Errors while parsing for each
+// address header repaired this way.  The null Return-Path "<>" also yields no addresses.
 func (e *Envelope) AddressList(key string) ([]*mail.Address, error) {
+	ret, _, err := e.addressList(key)
+	return ret, err
+}
+
+// addressList implements AddressList, also returning a description of the repair made if the
+// list was parsed leniently.
+func (e *Envelope) addressList(key string) ([]*mail.Address, string, error) {
 	if e.header == nil {
-		return nil, fmt.Errorf("No headers available")
+		return nil, "", fmt.Errorf("No headers available")
 	}
 	if !AddressHeaders[strings.ToLower(key)] {
-		return nil, fmt.Errorf("%s is not an address header", key)
+		return nil, "", fmt.Errorf("%s is not an address header", key)
 	}
 
 	str := decodeToUTF8Base64Header(e.header.Get(key))
 	if str == "" {
-		return nil, mail.ErrHeaderNotPresent
+		return nil, "", mail.ErrHeaderNotPresent
+	}
+	if strings.TrimSpace(str) == "<>" && strings.ToLower(key) == "return-path" {
+		// The null reverse-path, used by bounces and other automated messages
+		return []*mail.Address{}, "", nil
 	}
 	// These statements are handy for debugging ParseAddressList errors
 	// fmt.Println("in:  ", m.header.Get(key))
 	// fmt.Println("out: ", str)
+	var repair string
 	ret, err := mail.ParseAddressList(str)
 	if err != nil {
-		return nil, err
+		if ret, repair, err = lenientAddressList(key, str, err); err != nil {
+			return nil, "", err
+		}
+	}
+	for _, a := range ret {
+		if a.Name == "" {
+			a.Name = commentDisplayName(str, a.Address)
+		}
+	}
+	return ret, repair, nil
+}
+
+// lenientAddressList retries parsing an address list that mail.ParseAddressList rejected with err,
+// after removing comments and empty groups.  A description of the repair is returned if this
+// succeeds, otherwise err is returned.
+func lenientAddressList(key, list string, err error) ([]*mail.Address, string, error) {
+	lenient := strings.Trim(emptyGroupRegexp.ReplaceAllString(stripComments(list), ""), " \t,")
+	if lenient == "" {
+		return []*mail.Address{}, fmt.Sprintf("%s header %q contains no addresses", key, list), nil
+	}
+	ret, lerr := mail.ParseAddressList(lenient)
+	if lerr != nil {
+		return nil, "", err
+	}
+	return ret, fmt.Sprintf(
+		"%s header %q parsed as %q after removing comments and empty groups",
+		key,
+		list,
+		lenient), nil
+}
+
+// checkAddressHeaders adds a warning to the root Part for each address header that could only be
+// parsed leniently, see AddressList.
+func (e *Envelope) checkAddressHeaders() {
+	if e.header == nil {
+		return
+	}
+	keys := make([]string, 0, len(*e.header))
+	for k := range *e.header {
+		if AddressHeaders[strings.ToLower(k)] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if _, repair, err := e.addressList(k); err == nil && repair != "" {
+			e.Root.addWarning(errorMalformedHeader, "%s", repair)
+		}
+	}
+}
+
+// emptyGroupRegexp matches an RFC 5322 group containing no addresses, ie "undisclosed-recipients:;"
+var emptyGroupRegexp = regexp.MustCompile(`[^,:;<>"]*:\s*;`)
+
+// stripComments removes the RFC 5322 comments from an address list, respecting quoted strings and
+// nested comments.  Comments within angle brackets are removed entirely, elsewhere they are
+// replaced with a space.
+func stripComments(s string) string {
+	var b bytes.Buffer
+	depth, angle := 0, 0
+	quoted, escaped := false, false
+	for _, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\' && (quoted || depth > 0):
+			escaped = true
+		case quoted:
+			quoted = r != '"'
+		case r == '(':
+			depth++
+		case r == ')' && depth > 0:
+			depth--
+			if depth == 0 && angle == 0 {
+				b.WriteRune(' ')
+			}
+			continue
+		case depth > 0:
+		case r == '"':
+			quoted = true
+		case r == '<':
+			angle++
+		case r == '>' && angle > 0:
+			angle--
+		}
+		if depth == 0 {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// commentDisplayName returns the decoded comment following addr in its entry of list, as in
+// "user@example.com (Display Name)", for use as a display name.  Returns an empty string if there
+// is no such comment.
+func commentDisplayName(list, addr string) string {
+	for _, entry := range splitAddressList(list) {
+		i := strings.Index(entry, addr)
+		if i == -1 || (i > 0 && !strings.ContainsRune(" \t:<", rune(entry[i-1]))) {
+			// Not this address, perhaps another ending with it
+			continue
+		}
+		rest := strings.TrimLeft(entry[i+len(addr):], " \t>")
+		if !strings.HasPrefix(rest, "(") {
+			continue
+		}
+		if end := strings.IndexByte(rest, ')'); end != -1 {
+			if name := strings.TrimSpace(decodeHeader(rest[1:end])); name != "" {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// splitAddressList splits an address list into its comma separated entries, ignoring commas within
+// quoted strings, comments and angle brackets.
+func splitAddressList(s string) []string {
+	var entries []string
+	depth, angle, start := 0, 0, 0
+	quoted, escaped := false, false
+	for i, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\' && (quoted || depth > 0):
+			escaped = true
+		case quoted:
+			quoted = r != '"'
+		case r == '(':
+			depth++
+		case r == ')' && depth > 0:
+			depth--
+		case depth > 0:
+		case r == '"':
+			quoted = true
+		case r == '<':
+			angle++
+		case r == '>' && angle > 0:
+			angle--
+		case r == ',' && angle == 0:
+			entries = append(entries, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(entries, s[start:])
+}
+
+// Comments returns the value of each Comments header, with RFC 2047 encoded words converted to
+// UTF-8.
+func (e *Envelope) Comments() []string {
+	return e.GetHeaderValues(hnComments)
+}
+
+// Keywords returns the comma separated phrases from all Keywords headers, with RFC 2047 encoded
+// words converted to UTF-8.  Empty phrases are omitted.
+func (e *Envelope) Keywords() []string {
+	if e.header == nil {
+		return nil
+	}
+	var keywords []string
+	for _, v := range (*e.header)[textproto.CanonicalMIMEHeaderKey(hnKeywords)] {
+		for _, k := range strings.Split(v, ",") {
+			if k = strings.TrimSpace(e.decodeHeader(k)); k != "" {
+				keywords = append(keywords, k)
+			}
+		}
+	}
+	return keywords
+}
+
+// Subject returns the Subject header with RFC 2047 encoded words converted to UTF-8 and
+// surrounding whitespace removed, or an empty string if there is none.  Folded lines are joined
+// with a single space, and whitespace between adjacent encoded words is dropped.
+func (e *Envelope) Subject() string {
+	return strings.TrimSpace(e.GetHeader(hnSubject))
+}
+
+// MessageID returns the ID from the Message-ID header without angle brackets, or an empty string
+// if there is none.
+func (e *Envelope) MessageID() string {
+	if ids := e.messageIDs(hnMessageID); len(ids) > 0 {
+		return ids[0]
+	}
+	return ""
+}
+
+// InReplyTo returns the IDs from the In-Reply-To header without angle brackets.
+func (e *Envelope) InReplyTo() []string {
+	return e.messageIDs(hnInReplyTo)
+}
+
+// References returns the IDs from the References header without angle brackets, oldest first as
+// they appear in the header.
+func (e *Envelope) References() []string {
+	return e.messageIDs(hnReferences)
+}
+
+// messageIDs returns the IDs from all values of the named header.
+func (e *Envelope) messageIDs(name string) []string {
+	if e.header == nil {
+		return nil
+	}
+	var ids []string
+	for _, v := range (*e.header)[textproto.CanonicalMIMEHeaderKey(name)] {
+		ids = append(ids, parseMessageIDs(v)...)
+	}
+	return ids
+}
+
+// messageIDRegexp matches an angle bracketed message ID, capturing the ID.
+var messageIDRegexp = regexp.MustCompile(`<([^<>]*)>`)
+
+// parseMessageIDs extracts the angle bracketed message IDs from a header value, ignoring any
+// phrases or comments between them.  Whitespace within an ID, as left by folding, is removed.  If
+// value contains no angle brackets, the whitespace separated words containing an "@" are used.
+func parseMessageIDs(value string) []string {
+	var ids []string
+	for _, m := range messageIDRegexp.FindAllStringSubmatch(value, -1) {
+		if id := strings.Join(strings.Fields(m[1]), ""); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	if ids == nil && !strings.ContainsAny(value, "<>") {
+		for _, f := range strings.Fields(value) {
+			if strings.Contains(f, "@") {
+				ids = append(ids, f)
+			}
+		}
+	}
+	return ids
+}
+
+// HTMLText returns a plain text rendering of the HTML portion of the message: tags are stripped,
+// entities decoded, whitespace collapsed and paragraph breaks preserved.  It is useful when the
+// message did not include a text/plain part.  The Text field is not modified.  An empty string is
+// returned if there is no HTML, or it could not be converted.
+func (e *Envelope) HTMLText() string {
+	if e.HTML == "" {
+		return ""
+	}
+	text, err := html2text.FromString(e.HTML)
+	if err != nil {
+		return ""
+	}
+	return text
+}
+
+// Body returns the body of the message in the preferred representation, either "html" or "text",
+// falling back to the other when the message lacks it.  When text is preferred and the message
+// only has HTML, a plain text rendering of the HTML is returned.  Any other value of prefer is
+// treated as "text".
+func (e *Envelope) Body(prefer string) string {
+	if strings.EqualFold(prefer, "html") {
+		if e.HTML != "" {
+			return e.HTML
+		}
+		return e.Text
+	}
+	if e.Text != "" {
+		return e.Text
+	}
+	return e.HTMLText()
+}
+
+// InlineByContentID returns the Part with the specified Content-ID, or nil if there is none.  cid
+// may be given as a bare ID, in angle brackets, or as a "cid:" URL as used to reference inline
+// images from HTML.
+//
+// Matching ignores angle brackets and a "cid:" prefix on either side, as some mailers include the
+// scheme in the Content-ID header itself.  If either side lacks a domain, only the portion before
+// the "@" is compared, so "cid:foo" will match a Content-ID of "<foo@example.com>".
+func (e *Envelope) InlineByContentID(cid string) *Part {
+	if e.Root == nil {
+		return nil
+	}
+	return e.Root.DepthMatchFirst(func(p *Part) bool {
+		return contentIDMatches(cid, p.ContentID)
+	})
+}
+
+// ContentIDMap returns a map of the parts having a Content-ID, keyed by the ID without angle
+// brackets or any "cid:" prefix.  If more than one part has the same ID, the first in depth-first
+// order is used.  The map is built on the first call and cached, it should not be modified.
+// Unlike InlineByContentID, keys must match exactly.
+func (e *Envelope) ContentIDMap() map[string]*Part {
+	if e.contentIDs == nil {
+		e.contentIDs = make(map[string]*Part)
+		if e.Root != nil {
+			for _, p := range e.Root.DepthMatchAll(func(p *Part) bool {
+				return p.ContentID != ""
+			}) {
+				id := normalizeContentID(p.ContentID)
+				if _, ok := e.contentIDs[id]; !ok && id != "" {
+					e.contentIDs[id] = p
+				}
+			}
+		}
+	}
+	return e.contentIDs
+}
+
+// contentIDMatches implements the matching rule described in InlineByContentID.
+func contentIDMatches(ref, id string) bool {
+	ref, id = normalizeContentID(ref), normalizeContentID(id)
+	if ref == "" || id == "" {
+		return false
+	}
+	if ref == id {
+		return true
+	}
+	refAt, idAt := strings.Index(ref, "@"), strings.Index(id, "@")
+	if refAt != -1 && idAt != -1 {
+		// Both have a domain, and they did not match
+		return false
+	}
+	if refAt != -1 {
+		ref = ref[:refAt]
+	}
+	if idAt != -1 {
+		id = id[:idAt]
+	}
+	return ref == id
+}
+
+// normalizeContentID removes surrounding whitespace, angle brackets and any "cid:" prefix from a
+// Content-ID or cid URL.
+func normalizeContentID(id string) string {
+	id = trimAngleBrackets(id)
+	if len(id) >= 4 && strings.EqualFold(id[:4], "cid:") {
+		id = trimAngleBrackets(id[4:])
+	}
+	return id
+}
+
+// Walk calls fn for each Part of the message in depth first order, starting with Root.  See
+// Part.Walk for details.
+func (e *Envelope) Walk(fn func(*Part) error) error {
+	if e.Root == nil {
+		return nil
+	}
+	return e.Root.Walk(fn)
+}
+
+// PartsByType returns all parts in the tree with a Content-Type matching pattern, in depth-first
+// order.  The pattern may be an exact type such as "text/html", or use a "*" wildcard for the type
+// or subtype, as in "image/*" or "*/*".  Matching is case-insensitive.
+func (e *Envelope) PartsByType(pattern string) []*Part {
+	if e.Root == nil {
+		return nil
+	}
+	pattern = strings.ToLower(pattern)
+	return e.Root.DepthMatchAll(func(p *Part) bool {
+		matched, err := path.Match(pattern, strings.ToLower(p.ContentType))
+		return err == nil && matched
+	})
+}
+
+// AttachmentDigest holds the digest of the decoded content of an attachment, see
+// Envelope.AttachmentDigests.
+type AttachmentDigest struct {
+	FileName string // File name of the attachment, which may be empty or shared with another
+	SHA256   string // Hex encoded SHA-256 digest of the decoded content
+}
+
+// AttachmentDigests returns the digest of each attachment's decoded content, in the order of
+// Attachments.  Content is streamed into the hash one attachment at a time, including content held
+// in a temporary file due to Parser.InMemoryThreshold.
+func (e *Envelope) AttachmentDigests() ([]AttachmentDigest, error) {
+	digests := make([]AttachmentDigest, 0, len(e.Attachments))
+	for _, p := range e.Attachments {
+		h := sha256.New()
+		if err := p.writeContent(h); err != nil {
+			return nil, err
+		}
+		digests = append(digests, AttachmentDigest{
+			FileName: p.FileName,
+			SHA256:   hex.EncodeToString(h.Sum(nil)),
+		})
+	}
+	return digests, nil
+}
+
+// maxEmbeddedDepth limits how deeply embedded messages are parsed, see AllAttachments.
+const maxEmbeddedDepth = 10
+
+// AllAttachments returns the Attachments of the message, followed by those of any embedded
+// message/rfc822 parts, recursively.  Embedded messages are parsed along with the message, using
+// the options of the Parser other than PartFunc, and their temporary files are removed by Cleanup.
+// The root Part of each is given the message/rfc822 Part as its Parent, so the Parent links of
+// every returned Part lead back to Root, and Depth reflects its position in the message as a
+// whole.  Embedded messages that fail to parse are skipped.
+func (e *Envelope) AllAttachments() []*Part {
+	attachments := append([]*Part(nil), e.Attachments...)
+	for _, inner := range e.embedded {
+		attachments = append(attachments, inner.AllAttachments()...)
+	}
+	return attachments
+}
+
+// parseEmbedded parses the message/rfc822 parts of the message into e.embedded, taking ownership
+// of their temporary files.  Messages are parsed to a depth of maxEmbeddedDepth.
+func (e *Envelope) parseEmbedded() {
+	root := e.messageRoot()
+	if root == nil || e.opts.embeddedDepth >= maxEmbeddedDepth {
+		return
+	}
+	opts := e.opts.parseState()
+	opts.PartFunc = nil
+	opts.embeddedDepth++
+	embedded := root.DepthMatchAll(func(p *Part) bool {
+		return p.ContentType == ctMessageRFC822
+	})
+	for _, p := range embedded {
+		r, err := p.contentReader()
+		if err != nil {
+			continue
+		}
+		inner, err := opts.ReadEnvelope(r)
+		_ = r.Close()
+		if err != nil {
+			continue
+		}
+		inner.messageRoot().Parent = p
+		e.embedded = append(e.embedded, inner)
+		// Cleanup of the enclosing message removes the temporary files of this one
+		e.opts.tempPaths = append(e.opts.tempPaths, inner.opts.tempPaths...)
 	}
-	return ret, nil
 }
 
 // ReadEnvelope is a wrapper around ReadParts and EnvelopeFromPart.  It parses the content of the
 // provided reader into an Envelope, downconverting HTML to plain text if needed, and sorting the
 // attachments, inlines and other parts into their respective slices. Errors are collected from all
-// Parts and placed into the Envelope.Errors slice.
+// Parts and placed into the Envelope.Errors slice.  ErrEmptyMessage is returned if the reader
+// contains no data.
 func ReadEnvelope(r io.Reader) (*Envelope, error) {
-	// Read MIME parts from reader
-	root, err := ReadParts(r)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to ReadParts: %v", err)
-	}
-	return EnvelopeFromPart(root)
+	return new(Parser).ReadEnvelope(r)
 }
 
 // EnvelopeFromPart uses the provided Part tree to build an Envelope, downconverting HTML to plain
 // text if needed, and sorting the attachments, inlines and other parts into their respective
 // slices.  Errors are collected from all Parts and placed into the Envelopes Errors slice.
-func EnvelopeFromPart(root *Part) (*Envelope, error) {
+func EnvelopeFromPart(root *Part) (e *Envelope, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, err = nil, panicError(r)
+		}
+	}()
+
+	opts := &Parser{}
+	if root != nil {
+		// Adopt the temporary files of the tree, so that Cleanup removes them
+		for _, p := range root.DepthMatchAll(func(p *Part) bool { return p.TempPath != "" }) {
+			opts.tempPaths = append(opts.tempPaths, p.TempPath)
+		}
+	}
+	return envelopeFromPart(root, opts)
+}
+
+// envelopeFromPart implements EnvelopeFromPart, using the options specified in opts.
+func envelopeFromPart(root *Part, opts *Parser) (*Envelope, error) {
 	e := &Envelope{
 		Root:   root,
 		header: &root.Header,
+		opts:   opts,
 	}
 
 	if isMultipartMessage(root) {
 		// Multi-part message (message with attachments, etc)
-		if err := parseMultiPartBody(root, e); err != nil {
+		if err := parseMultiPartBody(root, e, opts); err != nil {
 			return nil, err
 		}
 	} else {
@@ -114,6 +613,9 @@ func EnvelopeFromPart(root *Part) (*Envelope, error) {
 		}
 	}
 
+	e.checkAddressHeaders()
+	e.parseEmbedded()
+
 	// Copy part errors into Envelope
 	if e.Root != nil {
 		_ = e.Root.DepthMatchAll(func(part *Part) bool {
@@ -126,6 +628,7 @@ func EnvelopeFromPart(root *Part) (*Envelope, error) {
 			return false
 		})
 	}
+	e.collectStats()
 
 	return e, nil
 }
@@ -185,10 +688,11 @@ func parseBinaryOnlyBody(root *Part, e *Envelope) error {
 	}
 
 	// Determine and set headers for: content disposition, filename and character set
-	root.setupContentHeaders(mparams)
+	root.setupContentHeaders(mparams, e.opts.RawHeaderCharset)
 
 	// Add our part to the appropriate section of the Envelope
 	e.Root = NewPart(nil, mediatype)
+	e.parsedRoot = root
 
 	if root.Disposition == cdInline {
 		e.Inlines = append(e.Inlines, root)
@@ -199,8 +703,12 @@ func parseBinaryOnlyBody(root *Part, e *Envelope) error {
 	return nil
 }
 
+// defaultTextPartSeparator is placed between concatenated text/plain parts, see
+// Parser.TextPartSeparator.
+const defaultTextPartSeparator = "\n\n"
+
 // parseMultiPartBody parses a multipart message in root.  The result is placed in e.
-func parseMultiPartBody(root *Part, e *Envelope) error {
+func parseMultiPartBody(root *Part, e *Envelope, opts *Parser) error {
 	// Parse top-level multipart
 	ctype := root.Header.Get(hnContentType)
 	mediatype, params, err := parseMediaType(ctype)
@@ -232,9 +740,13 @@ func parseMultiPartBody(root *Part, e *Envelope) error {
 		parts := root.DepthMatchAll(func(p *Part) bool {
 			return p.ContentType == ctTextPlain && p.Disposition != cdAttachment
 		})
+		sep := opts.TextPartSeparator
+		if sep == "" {
+			sep = defaultTextPartSeparator
+		}
 		for i, p := range parts {
 			if i > 0 {
-				e.Text += "\n--\n"
+				e.Text += sep
 			}
 			allBytes, ioerr := ioutil.ReadAll(p)
 			if ioerr != nil {
@@ -256,12 +768,13 @@ func parseMultiPartBody(root *Part, e *Envelope) error {
 
 	// Locate attachments
 	e.Attachments = root.BreadthMatchAll(func(p *Part) bool {
-		return p.Disposition == cdAttachment || p.ContentType == ctAppOctetStream
+		return p.Disposition == cdAttachment || p.ContentType == ctAppOctetStream ||
+			implicitDisposition(p) == cdAttachment
 	})
 
 	// Locate inlines
 	e.Inlines = root.BreadthMatchAll(func(p *Part) bool {
-		return p.Disposition == cdInline
+		return p.Disposition == cdInline || implicitDisposition(p) == cdInline
 	})
 
 	// Locate others parts not considered in attachments or inlines
@@ -269,7 +782,7 @@ func parseMultiPartBody(root *Part, e *Envelope) error {
 		if strings.HasPrefix(p.ContentType, ctMultipartPrefix) {
 			return false
 		}
-		if p.Disposition != "" {
+		if p.Disposition != "" || implicitDisposition(p) != "" {
 			return false
 		}
 		if p.ContentType == ctAppOctetStream {
@@ -281,17 +794,48 @@ func parseMultiPartBody(root *Part, e *Envelope) error {
 	return nil
 }
 
+// implicitDisposition classifies an image part lacking a Content-Disposition header using its
+// context.  Images within multipart/related are inline, as are images with a Content-ID which may
+// be referenced from HTML.  Other images, for example within multipart/mixed, are attachments.
+// Application, audio and video parts of a multipart/mixed, such as a PDF sent beside a
+// multipart/alternative body, are also attachments.  Returns an empty string for other parts, or
+// parts with a disposition.
+func implicitDisposition(p *Part) string {
+	if p.Disposition != "" {
+		return ""
+	}
+	if !strings.HasPrefix(p.ContentType, ctImagePrefix) {
+		if p.Parent != nil && p.Parent.ContentType == ctMultipartMixed && isDataPart(p) {
+			return cdAttachment
+		}
+		return ""
+	}
+	if p.Parent != nil && p.Parent.ContentType == ctMultipartRelated {
+		return cdInline
+	}
+	if p.ContentID != "" {
+		return cdInline
+	}
+	return cdAttachment
+}
+
+// isDataPart returns true if the media type of p is application, audio or video.
+func isDataPart(p *Part) bool {
+	mainType, _ := p.ContentTypeParts()
+	return mainType == "application" || mainType == "audio" || mainType == "video"
+}
+
 // isMultipartMessage returns true if the message has a recognized multipart Content-Type header.
 func isMultipartMessage(root *Part) bool {
 	// Parse top-level multipart
 	ctype := root.Header.Get(hnContentType)
-	mediatype, _, err := parseMediaType(ctype)
+	mediatype, params, err := parseMediaType(ctype)
 	if err != nil {
 		return false
 	}
 	// According to rfc2046#section-5.1.7 all other multipart should
-	// be treated as multipart/mixed
-	return strings.HasPrefix(mediatype, ctMultipartPrefix)
+	// be treated as multipart/mixed.  Without a boundary the content was parsed as a single body
+	return strings.HasPrefix(mediatype, ctMultipartPrefix) && params[hpBoundary] != ""
 }
 
 // isAttachment returns true, if the given header defines an attachment.  First it checks if the
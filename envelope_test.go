@@ -2,8 +2,12 @@ package enmime
 
 import (
 	"bytes"
+	"errors"
 	"io/ioutil"
+	"net/mail"
 	"net/textproto"
+	"os"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -132,6 +136,67 @@ func TestParseNonMimeHTML(t *testing.T) {
 	}
 }
 
+func TestEnvelopeHTMLText(t *testing.T) {
+	raw := "From: user@inbucket.org\r\n" +
+		"Subject: HTML only\r\n" +
+		"Content-Type: text/html; charset=utf-8\r\n" +
+		"\r\n" +
+		"<html><body><p>Hello &amp; welcome</p>\r\n" +
+		"<p>Second    paragraph</p></body></html>\r\n"
+	e, err := ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+
+	text := e.HTMLText()
+	for _, want := range []string{"Hello & welcome", "Second paragraph", "welcome\n\nSecond"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("HTMLText() == %q, should contain: %q", text, want)
+		}
+	}
+	if strings.ContainsAny(text, "<>") {
+		t.Errorf("HTMLText() == %q, should not contain tags", text)
+	}
+
+	// Existing Text must not be overwritten
+	e.Text = "original"
+	_ = e.HTMLText()
+	if e.Text != "original" {
+		t.Errorf("e.Text == %q, want: %q", e.Text, "original")
+	}
+
+	e.HTML = ""
+	if got := e.HTMLText(); got != "" {
+		t.Errorf("HTMLText() without HTML == %q, want empty", got)
+	}
+}
+
+func TestEnvelopeBody(t *testing.T) {
+	e, err := ReadEnvelope(openTestData("mail", "html-mime-inline.raw"))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+	if e.Text == "" || e.HTML == "" {
+		t.Fatal("Expected message to have both Text and HTML")
+	}
+	if got := e.Body("html"); got != e.HTML {
+		t.Errorf("Body(\"html\") == %q, want HTML: %q", got, e.HTML)
+	}
+	if got := e.Body("text"); got != e.Text {
+		t.Errorf("Body(\"text\") == %q, want Text: %q", got, e.Text)
+	}
+
+	// Falls back to the representation available
+	textOnly := &Envelope{Text: "plain"}
+	if got := textOnly.Body("html"); got != "plain" {
+		t.Errorf("Body(\"html\") of text only message == %q, want: %q", got, "plain")
+	}
+	htmlOnly := &Envelope{HTML: "<p>Hello &amp; welcome</p>"}
+	if got := htmlOnly.Body("text"); got != "Hello & welcome" {
+		t.Errorf("Body(\"text\") of HTML only message == %q, want: %q", got, "Hello & welcome")
+	}
+}
+
 func TestParseMimeTree(t *testing.T) {
 	msg := openTestData("mail", "attachment.raw")
 	e, err := ReadEnvelope(msg)
@@ -208,12 +273,61 @@ func TestParseMultiMixedText(t *testing.T) {
 		t.Fatal("Failed to parse MIME:", err)
 	}
 
-	want := "Section one\n\n--\nSection two"
+	want := "Section one\n\n\nSection two"
 	if e.Text != want {
 		t.Error("Text parts should concatenate, got:", e.Text, "want:", want)
 	}
 }
 
+func TestParseMultiMixedAlternativeAttachment(t *testing.T) {
+	msg := openTestData("mail", "mime-mixed-alternative-pdf.raw")
+	e, err := ReadEnvelope(msg)
+
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+
+	want := "The report is attached."
+	if e.Text != want {
+		t.Errorf("Text got: %q, want: %q", e.Text, want)
+	}
+	want = "<p>The report is attached.</p>"
+	if e.HTML != want {
+		t.Errorf("HTML got: %q, want: %q", e.HTML, want)
+	}
+	if len(e.Attachments) != 1 {
+		t.Fatalf("Got %v attachments, want 1", len(e.Attachments))
+	}
+	a := e.Attachments[0]
+	if a.ContentType != "application/pdf" || a.FileName != "report.pdf" {
+		t.Errorf("Attachment got: %q %q, want: %q %q", a.ContentType, a.FileName,
+			"application/pdf", "report.pdf")
+	}
+	if !bytes.HasPrefix(a.Content, []byte("%PDF-1.4")) {
+		t.Errorf("Attachment Content got: %q, want a PDF", a.Content)
+	}
+	if len(e.Inlines) != 0 {
+		t.Errorf("Got %v inlines, want 0", len(e.Inlines))
+	}
+	if len(e.OtherParts) != 0 {
+		t.Errorf("Got %v other parts, want 0", len(e.OtherParts))
+	}
+}
+
+func TestParseMultiMixedTextSeparator(t *testing.T) {
+	msg := openTestData("mail", "mime-mixed.raw")
+	e, err := (&Parser{TextPartSeparator: "\n--\n"}).ReadEnvelope(msg)
+
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+
+	want := "Section one\n\n--\nSection two"
+	if e.Text != want {
+		t.Errorf("Text got: %q, want: %q", e.Text, want)
+	}
+}
+
 func TestParseMultiSignedText(t *testing.T) {
 	msg := openTestData("mail", "mime-signed.raw")
 	e, err := ReadEnvelope(msg)
@@ -222,7 +336,7 @@ func TestParseMultiSignedText(t *testing.T) {
 		t.Fatal("Failed to parse MIME:", err)
 	}
 
-	want := "Section one\n\n--\nSection two"
+	want := "Section one\n\n\nSection two"
 	if e.Text != want {
 		t.Error("Text parts should concatenate, got:", e.Text, "want:", want)
 	}
@@ -309,6 +423,246 @@ func TestParseAttachment(t *testing.T) {
 	}
 }
 
+func TestEnvelopeAttachmentDigests(t *testing.T) {
+	msg := openTestData("mail", "attachment.raw")
+	e, err := ReadEnvelope(msg)
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+
+	want := []AttachmentDigest{
+		{"test.html", "b53a55383d2f1f040ab010606d7911907f1a17f979f1d475fb4ac226243135e5"},
+	}
+	got, err := e.AttachmentDigests()
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("AttachmentDigests() got: %v, %v, want: %v", got, err, want)
+	}
+	// Digests must be repeatable
+	got, err = e.AttachmentDigests()
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Second AttachmentDigests() got: %v, %v, want: %v", got, err, want)
+	}
+}
+
+func TestEnvelopeAttachmentDigestsDuplicateNames(t *testing.T) {
+	raw := "From: user@inbucket.org\r\n" +
+		"Content-Type: multipart/mixed; boundary=Enmime-100\r\n" +
+		"\r\n" +
+		"--Enmime-100\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"body\r\n" +
+		"--Enmime-100\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"Content-Disposition: attachment; filename=a.txt\r\n" +
+		"\r\n" +
+		"one\r\n" +
+		"--Enmime-100\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"Content-Disposition: attachment; filename=a.txt\r\n" +
+		"\r\n" +
+		"two\r\n" +
+		"--Enmime-100\r\n" +
+		"Content-Type: application/octet-stream\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		"b25l\r\n" +
+		"--Enmime-100--\r\n"
+	e, err := ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+
+	want := []AttachmentDigest{
+		{"a.txt", "7692c3ad3540bb803c020b3aee66cd8887123234ea0c6e7143c0add73ff431ed"},
+		{"a.txt", "3fc4ccfe745870e2c0d99f71f30ff0656c8dedd41cc1d7d3d376b0dbe685e2f3"},
+		{"", "7692c3ad3540bb803c020b3aee66cd8887123234ea0c6e7143c0add73ff431ed"},
+	}
+	got, err := e.AttachmentDigests()
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("AttachmentDigests() got: %v, %v, want: %v", got, err, want)
+	}
+}
+
+func TestEnvelopeInlineByContentID(t *testing.T) {
+	raw := "From: user@inbucket.org\r\n" +
+		"Content-Type: multipart/related; boundary=Enmime-100\r\n" +
+		"\r\n" +
+		"--Enmime-100\r\n" +
+		"Content-Type: text/html\r\n" +
+		"\r\n" +
+		"<img src=\"cid:logo@x\">\r\n" +
+		"--Enmime-100\r\n" +
+		"Content-Type: image/png\r\n" +
+		"Content-Disposition: inline; filename=logo.png\r\n" +
+		"Content-ID: <logo@x>\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		"iVBORw0KGgo=\r\n" +
+		"--Enmime-100--\r\n"
+	e, err := ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+	if len(e.Inlines) != 1 {
+		t.Fatalf("len(e.Inlines) == %v, want: 1", len(e.Inlines))
+	}
+	if got := e.Inlines[0].ContentID; got != "logo@x" {
+		t.Errorf("Part.ContentID == %q, want: %q", got, "logo@x")
+	}
+
+	for _, cid := range []string{"cid:logo@x", "<logo@x>", "logo@x"} {
+		p := e.InlineByContentID(cid)
+		if p == nil {
+			t.Errorf("InlineByContentID(%q) == nil, want a part", cid)
+			continue
+		}
+		if p.FileName != "logo.png" {
+			t.Errorf("InlineByContentID(%q).FileName == %q, want: %q", cid, p.FileName, "logo.png")
+		}
+	}
+	for _, cid := range []string{"cid:other@x", "cid:logo@y", "cid:other", ""} {
+		if p := e.InlineByContentID(cid); p != nil {
+			t.Errorf("InlineByContentID(%q) == %+v, want nil", cid, p)
+		}
+	}
+}
+
+func TestEnvelopeInlineByContentIDScheme(t *testing.T) {
+	raw := "From: user@inbucket.org\r\n" +
+		"Content-Type: multipart/related; boundary=Enmime-100\r\n" +
+		"\r\n" +
+		"--Enmime-100\r\n" +
+		"Content-Type: text/html\r\n" +
+		"\r\n" +
+		"<img src=\"cid:logo\">\r\n" +
+		"--Enmime-100\r\n" +
+		"Content-Type: image/png\r\n" +
+		"Content-Disposition: inline; filename=logo.png\r\n" +
+		"Content-ID: <cid:logo@example.com>\r\n" +
+		"\r\n" +
+		"PNG\r\n" +
+		"--Enmime-100--\r\n"
+	e, err := ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+	if len(e.Inlines) != 1 {
+		t.Fatalf("len(e.Inlines) == %v, want: 1", len(e.Inlines))
+	}
+	if got, want := e.Inlines[0].ContentID, "cid:logo@example.com"; got != want {
+		t.Errorf("Part.ContentID == %q, want: %q", got, want)
+	}
+
+	for _, cid := range []string{"cid:logo", "cid:logo@example.com", "logo@example.com",
+		"CID:logo"} {
+		if p := e.InlineByContentID(cid); p == nil {
+			t.Errorf("InlineByContentID(%q) == nil, want a part", cid)
+		}
+	}
+	if p := e.InlineByContentID("cid:logo@example.org"); p != nil {
+		t.Errorf("InlineByContentID(%q) == %+v, want nil", "cid:logo@example.org", p)
+	}
+}
+
+func TestEnvelopeContentIDMap(t *testing.T) {
+	raw := "From: user@inbucket.org\r\n" +
+		"Content-Type: multipart/related; boundary=Enmime-100\r\n" +
+		"\r\n" +
+		"--Enmime-100\r\n" +
+		"Content-Type: text/html\r\n" +
+		"\r\n" +
+		"<img src=\"cid:one@x\"><img src=\"cid:two@x\"><img src=\"cid:three\">\r\n" +
+		"--Enmime-100\r\n" +
+		"Content-Type: image/png\r\n" +
+		"Content-Disposition: inline; filename=one.png\r\n" +
+		"Content-ID: <one@x>\r\n" +
+		"\r\n" +
+		"PNG\r\n" +
+		"--Enmime-100\r\n" +
+		"Content-Type: image/gif\r\n" +
+		"Content-Disposition: inline; filename=two.gif\r\n" +
+		"Content-ID: <two@x>\r\n" +
+		"\r\n" +
+		"GIF\r\n" +
+		"--Enmime-100\r\n" +
+		"Content-Type: image/jpeg\r\n" +
+		"Content-Disposition: inline; filename=three.jpg\r\n" +
+		"Content-ID: <cid:three>\r\n" +
+		"\r\n" +
+		"JPEG\r\n" +
+		"--Enmime-100--\r\n"
+	e, err := ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+
+	want := map[string]string{
+		"one@x": "one.png",
+		"two@x": "two.gif",
+		"three": "three.jpg",
+	}
+	got := e.ContentIDMap()
+	if len(got) != len(want) {
+		t.Errorf("len(ContentIDMap()) == %v, want: %v", len(got), len(want))
+	}
+	for id, fileName := range want {
+		p := got[id]
+		if p == nil {
+			t.Errorf("ContentIDMap()[%q] == nil, want a part", id)
+			continue
+		}
+		if p.FileName != fileName {
+			t.Errorf("ContentIDMap()[%q].FileName == %q, want: %q", id, p.FileName, fileName)
+		}
+		if p != e.InlineByContentID(id) {
+			t.Errorf("ContentIDMap()[%q] differs from InlineByContentID", id)
+		}
+	}
+
+	// The map is cached
+	got["extra"] = nil
+	if _, ok := e.ContentIDMap()["extra"]; !ok {
+		t.Error("ContentIDMap() was rebuilt, want cached map")
+	}
+
+	if got := (&Envelope{}).ContentIDMap(); len(got) != 0 {
+		t.Errorf("ContentIDMap() of empty Envelope == %v, want empty map", got)
+	}
+}
+
+func TestEnvelopePartsByType(t *testing.T) {
+	msg := openTestData("mail", "html-mime-inline.raw")
+	e, err := ReadEnvelope(msg)
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+
+	ttable := []struct {
+		pattern string
+		want    []string
+	}{
+		{"image/*", []string{"image/png"}},
+		{"text/html", []string{"text/html"}},
+		{"TEXT/*", []string{"text/plain", "text/html"}},
+		{"*/*", []string{
+			"multipart/alternative", "text/plain", "multipart/related", "text/html", "image/png"}},
+		{"video/*", []string{}},
+		{"[", []string{}},
+	}
+
+	for _, tt := range ttable {
+		parts := e.PartsByType(tt.pattern)
+		got := make([]string, 0, len(parts))
+		for _, p := range parts {
+			got = append(got, p.ContentType)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("PartsByType(%q) got: %q, want: %q", tt.pattern, got, tt.want)
+		}
+	}
+}
+
 func TestParseAttachmentOctet(t *testing.T) {
 	msg := openTestData("mail", "attachment-octet.raw")
 	e, err := ReadEnvelope(msg)
@@ -388,18 +742,19 @@ func TestParseOtherParts(t *testing.T) {
 	if e.HTML != "" {
 		t.Error("mime.HTML should be empty, attachment is not for display, got:", e.HTML)
 	}
-	if len(e.Inlines) > 0 {
-		t.Error("Should have no inlines, got:", len(e.Inlines))
+	// The image has no disposition, but its Content-ID makes it an inline
+	if len(e.OtherParts) > 0 {
+		t.Error("Should have no other parts, got:", len(e.OtherParts))
 	}
 	if len(e.Attachments) > 0 {
 		t.Fatal("Should have no attachments, got:", len(e.Attachments))
 	}
-	if len(e.OtherParts) != 1 {
-		t.Fatal("Should have one other part, got:", len(e.OtherParts))
+	if len(e.Inlines) != 1 {
+		t.Fatal("Should have one inline, got:", len(e.Inlines))
 	}
 
 	want = "B05.gif"
-	got := e.OtherParts[0].FileName
+	got := e.Inlines[0].FileName
 	if got != want {
 		t.Error("FileName got:", got, "want:", want)
 	}
@@ -414,12 +769,56 @@ func TestParseOtherParts(t *testing.T) {
 		0x80, 0xf1, 0x18, 0x84, 0xc0, 0x9e, 0xd0, 0xe8, 0xf2, 0x1, 0xb5, 0x19, 0xad, 0x41,
 		0x53, 0x33, 0x9b, 0x0, 0x0, 0x3b,
 	}
-	allBytes, err := ioutil.ReadAll(e.OtherParts[0])
+	allBytes, err := ioutil.ReadAll(e.Inlines[0])
 	if err != nil {
 		t.Fatal(err)
 	}
 	if !bytes.Equal(allBytes, wantBytes) {
-		t.Error("Other part should have correct content")
+		t.Error("Inline part should have correct content")
+	}
+}
+
+func TestImplicitImageDisposition(t *testing.T) {
+	image := func(headers string) string {
+		return "Content-Type: image/png\r\n" + headers + "\r\nPNG\r\n"
+	}
+	testCases := []struct {
+		name        string
+		ctype       string
+		image       string
+		inlines     int
+		attachments int
+	}{
+		{"related", "multipart/related", image(""), 1, 0},
+		{"mixed", "multipart/mixed", image(""), 0, 1},
+		{"mixed with Content-ID", "multipart/mixed", image("Content-ID: <a@b>\r\n"), 1, 0},
+		{"explicit attachment", "multipart/related",
+			image("Content-Disposition: attachment\r\n"), 0, 1},
+	}
+	for _, tc := range testCases {
+		raw := "Content-Type: " + tc.ctype + "; boundary=Enmime\r\n" +
+			"\r\n" +
+			"--Enmime\r\n" +
+			"Content-Type: text/html\r\n" +
+			"\r\n" +
+			"<img src=\"cid:a@b\">\r\n" +
+			"--Enmime\r\n" +
+			tc.image +
+			"--Enmime--\r\n"
+		e, err := ReadEnvelope(strings.NewReader(raw))
+		if err != nil {
+			t.Fatal(tc.name, "Failed to parse MIME:", err)
+		}
+		if len(e.Inlines) != tc.inlines {
+			t.Errorf("%s: got %v inlines, want %v", tc.name, len(e.Inlines), tc.inlines)
+		}
+		if len(e.Attachments) != tc.attachments {
+			t.Errorf("%s: got %v attachments, want %v", tc.name, len(e.Attachments),
+				tc.attachments)
+		}
+		if len(e.OtherParts) != 0 {
+			t.Errorf("%s: got %v other parts, want 0", tc.name, len(e.OtherParts))
+		}
 	}
 }
 
@@ -572,6 +971,31 @@ func TestEnvelopeGetHeader(t *testing.T) {
 	}
 }
 
+func TestEnvelopeGetHeaderRawCharset(t *testing.T) {
+	raw := "From: user@inbucket.org\r\nSubject: Caf\xe9 cr\xe8me\r\n\r\nBody\r\n"
+	e, err := ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+
+	want := "Caf\xe9 cr\xe8me"
+	got := e.GetHeader("Subject")
+	if got != want {
+		t.Errorf("Subject without RawHeaderCharset got: %q, want: %q", got, want)
+	}
+
+	p := &Parser{RawHeaderCharset: "iso-8859-1"}
+	e, err = p.ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+	want = "Caf\u00e9 cr\u00e8me"
+	got = e.GetHeader("Subject")
+	if got != want {
+		t.Errorf("Subject with RawHeaderCharset got: %q, want: %q", got, want)
+	}
+}
+
 func TestEnvelopeAddressList(t *testing.T) {
 	var e *Envelope
 	var got, want string
@@ -792,7 +1216,7 @@ func TestEnvelopeParseMultiPartBody(t *testing.T) {
 	e := &Envelope{}
 
 	// Empty root part
-	err = parseMultiPartBody(root, e)
+	err = parseMultiPartBody(root, e, &Parser{})
 	want = "Unable to parse media type"
 	if err == nil {
 		t.Fatalf("err was %v, wanted: %v", err, want)
@@ -804,7 +1228,7 @@ func TestEnvelopeParseMultiPartBody(t *testing.T) {
 	// Unexpected content type
 	root.Header = make(textproto.MIMEHeader)
 	root.Header.Set("Content-Type", "text/plain")
-	err = parseMultiPartBody(root, e)
+	err = parseMultiPartBody(root, e, &Parser{})
 	want = "Unknown mediatype"
 	if err == nil {
 		t.Fatalf("err was %v, wanted: %v", err, want)
@@ -816,7 +1240,7 @@ func TestEnvelopeParseMultiPartBody(t *testing.T) {
 	// No boundary param
 	root.Header = make(textproto.MIMEHeader)
 	root.Header.Set("Content-Type", "multipart/mixed")
-	err = parseMultiPartBody(root, e)
+	err = parseMultiPartBody(root, e, &Parser{})
 	want = "Unable to locate boundary param"
 	if err == nil {
 		t.Fatalf("err was %v, wanted: %v", err, want)
@@ -970,3 +1394,478 @@ func TestInlineTextBody(t *testing.T) {
 		}
 	}
 }
+
+func TestEnvelopeCommentsAndKeywords(t *testing.T) {
+	raw := "From: user@inbucket.org\r\n" +
+		"Comments: =?UTF-8?Q?Caf=C3=A9?= meeting\r\n" +
+		"Comments: Second\r\n" +
+		" comment\r\n" +
+		"Keywords: alpha, =?ISO-8859-1?Q?cr=E8me?=,\r\n" +
+		"\tgamma\r\n" +
+		"Keywords: delta,,\r\n" +
+		"\r\n" +
+		"Body\r\n"
+	e, err := ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+
+	want := []string{"Café meeting", "Second comment"}
+	if got := e.Comments(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Comments() got: %q, want: %q", got, want)
+	}
+	want = []string{"alpha", "crème", "gamma", "delta"}
+	if got := e.Keywords(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Keywords() got: %q, want: %q", got, want)
+	}
+
+	e = &Envelope{}
+	if got := e.Keywords(); got != nil {
+		t.Errorf("Keywords() with no header got: %q, want nil", got)
+	}
+}
+
+func TestEnvelopeSubject(t *testing.T) {
+	testCases := []struct {
+		header, want string
+	}{
+		{"Subject: Plain\r\n", "Plain"},
+		{"Subject:   padded  \r\n", "padded"},
+		{"Subject: =?UTF-8?Q?Caf=C3=A9?=\r\n =?UTF-8?Q?_cr=C3=A8me?=\r\n\tand more\r\n",
+			"Café crème and more"},
+		{"Subject: =?ISO-8859-1?Q?a?= =?UTF-8?B?w6k=?=b\r\n", "aéb"},
+		{"", ""},
+	}
+	for _, tc := range testCases {
+		raw := "From: user@inbucket.org\r\n" + tc.header + "\r\nBody\r\n"
+		e, err := ReadEnvelope(strings.NewReader(raw))
+		if err != nil {
+			t.Fatal("Failed to parse MIME:", err)
+		}
+		if got := e.Subject(); got != tc.want {
+			t.Errorf("Subject() for %q got: %q, want: %q", tc.header, got, tc.want)
+		}
+	}
+	if got := (&Envelope{}).Subject(); got != "" {
+		t.Errorf("Subject() with no header got: %q, want empty", got)
+	}
+}
+
+func TestEnvelopeThreading(t *testing.T) {
+	raw := "From: user@inbucket.org\r\n" +
+		"Message-ID: <reply.3@example.com>\r\n" +
+		"In-Reply-To: <second.2@example.com> (Bob's message)\r\n" +
+		"References: <first.1@example.com>\r\n" +
+		"\t<second.2@example.com>\r\n" +
+		" <folded.in.\r\n" +
+		" id@example.com>\r\n" +
+		"\r\n" +
+		"Body\r\n"
+	e, err := ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+
+	if got, want := e.MessageID(), "reply.3@example.com"; got != want {
+		t.Errorf("MessageID() got: %q, want: %q", got, want)
+	}
+	want := []string{"second.2@example.com"}
+	if got := e.InReplyTo(); !reflect.DeepEqual(got, want) {
+		t.Errorf("InReplyTo() got: %q, want: %q", got, want)
+	}
+	want = []string{"first.1@example.com", "second.2@example.com", "folded.in.id@example.com"}
+	if got := e.References(); !reflect.DeepEqual(got, want) {
+		t.Errorf("References() got: %q, want: %q", got, want)
+	}
+
+	e = &Envelope{}
+	if got := e.MessageID(); got != "" {
+		t.Errorf("MessageID() with no header got: %q, want empty", got)
+	}
+	if got := e.References(); got != nil {
+		t.Errorf("References() with no header got: %q, want nil", got)
+	}
+}
+
+func TestParseMessageIDs(t *testing.T) {
+	testCases := []struct {
+		input string
+		want  []string
+	}{
+		{"", nil},
+		{"<a@b>", []string{"a@b"}},
+		{"<a@b><c@d>", []string{"a@b", "c@d"}},
+		{"Your message of Monday <a@b>", []string{"a@b"}},
+		{"a@b c@d", []string{"a@b", "c@d"}},
+		{"Your message of Monday", nil},
+		{"<>", nil},
+	}
+	for _, tc := range testCases {
+		if got := parseMessageIDs(tc.input); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseMessageIDs(%q) got: %q, want: %q", tc.input, got, tc.want)
+		}
+	}
+}
+
+func TestEnvelopeGetHeaderValues(t *testing.T) {
+	raw := "Received: from a.example.com by b.example.com\r\n" +
+		"From: user@inbucket.org\r\n" +
+		"Received: from c.example.com\r\n" +
+		"\tby d.example.com\r\n" +
+		"Received: from e.example.com by f.example.com\r\n" +
+		"X-Note: =?UTF-8?Q?Caf=C3=A9?=\r\n" +
+		"\r\n" +
+		"Body\r\n"
+	e, err := ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+
+	want := []string{
+		"from a.example.com by b.example.com",
+		"from c.example.com by d.example.com",
+		"from e.example.com by f.example.com",
+	}
+	if got := e.GetHeaderValues("received"); !reflect.DeepEqual(got, want) {
+		t.Errorf("GetHeaderValues(received)\ngot : %q\nwant: %q", got, want)
+	}
+	want = []string{"Café"}
+	if got := e.GetHeaderValues("X-Note"); !reflect.DeepEqual(got, want) {
+		t.Errorf("GetHeaderValues(X-Note) got: %q, want: %q", got, want)
+	}
+	if got := e.GetHeaderValues("X-Missing"); got != nil {
+		t.Errorf("GetHeaderValues(X-Missing) got: %q, want nil", got)
+	}
+}
+
+func TestEnvelopeAddressListCommentName(t *testing.T) {
+	raw := "From: jose@example.com (=?UTF-8?Q?Jos=C3=A9?=)\r\n" +
+		"Sender: Named <named@example.com> (=?UTF-8?Q?ignored?=)\r\n" +
+		"To: plain@example.com (Plain Comment), other@example.com\r\n" +
+		"Cc: bob@example.com, jbob@example.com (Jim), \"x, y\" <z@example.com>\r\n" +
+		"\r\n" +
+		"Body\r\n"
+	e, err := ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+
+	testCases := []struct {
+		header string
+		want   []mail.Address
+	}{
+		{"From", []mail.Address{{Name: "José", Address: "jose@example.com"}}},
+		{"Sender", []mail.Address{{Name: "Named", Address: "named@example.com"}}},
+		{"To", []mail.Address{
+			{Name: "Plain Comment", Address: "plain@example.com"},
+			{Name: "", Address: "other@example.com"},
+		}},
+		// The comment belongs to the address that ends with the first
+		{"Cc", []mail.Address{
+			{Name: "", Address: "bob@example.com"},
+			{Name: "Jim", Address: "jbob@example.com"},
+			{Name: "x, y", Address: "z@example.com"},
+		}},
+	}
+	for _, tc := range testCases {
+		got, err := e.AddressList(tc.header)
+		if err != nil {
+			t.Errorf("AddressList(%q) error: %v", tc.header, err)
+			continue
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("AddressList(%q) got %v addresses, want %v", tc.header, len(got), len(tc.want))
+			continue
+		}
+		for i := range got {
+			if *got[i] != tc.want[i] {
+				t.Errorf("AddressList(%q)[%v] got: %+v, want: %+v", tc.header, i, *got[i], tc.want[i])
+			}
+		}
+	}
+}
+
+func TestEnvelopeSenderReturnPath(t *testing.T) {
+	testCases := []struct {
+		header, value string
+		want          []mail.Address
+	}{
+		{"Sender", "List Bot <bot@example.com>",
+			[]mail.Address{{Name: "List Bot", Address: "bot@example.com"}}},
+		{"Return-Path", "<bounces@example.com>", []mail.Address{{Address: "bounces@example.com"}}},
+		{"return-path", " <> ", []mail.Address{}},
+	}
+	for _, tc := range testCases {
+		raw := tc.header + ": " + tc.value + "\r\nContent-Type: text/plain\r\n\r\nBody\r\n"
+		e, err := ReadEnvelope(strings.NewReader(raw))
+		if err != nil {
+			t.Fatal("Failed to parse MIME:", err)
+		}
+		got, err := e.AddressList(tc.header)
+		if err != nil {
+			t.Errorf("AddressList(%q) for %q error: %v", tc.header, tc.value, err)
+			continue
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("AddressList(%q) for %q got %v addresses, want %v", tc.header, tc.value,
+				len(got), len(tc.want))
+			continue
+		}
+		for i := range got {
+			if *got[i] != tc.want[i] {
+				t.Errorf("AddressList(%q)[%v] got: %+v, want: %+v", tc.header, i, *got[i], tc.want[i])
+			}
+		}
+		if len(e.Errors) > 0 {
+			t.Errorf("AddressList(%q) for %q recorded errors: %v", tc.header, tc.value, e.Errors)
+		}
+	}
+}
+
+func TestEnvelopeAddressListLenient(t *testing.T) {
+	raw := "From: John Doe <john(work)@example.com>\r\n" +
+		"To: undisclosed-recipients:;\r\n" +
+		"Cc: a@example.com, (nested (comment)) Friends: ;, b@example.com\r\n" +
+		"Bcc: not an address\r\n" +
+		"\r\n" +
+		"Body\r\n"
+	e, err := ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+
+	testCases := []struct {
+		header string
+		want   []mail.Address
+	}{
+		{"From", []mail.Address{{Name: "John Doe", Address: "john@example.com"}}},
+		{"To", []mail.Address{}},
+		{"Cc", []mail.Address{{Address: "a@example.com"}, {Address: "b@example.com"}}},
+	}
+	for _, tc := range testCases {
+		got, err := e.AddressList(tc.header)
+		if err != nil {
+			t.Errorf("AddressList(%q) error: %v", tc.header, err)
+			continue
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("AddressList(%q) got %v addresses, want %v", tc.header, len(got), len(tc.want))
+			continue
+		}
+		for i := range got {
+			if *got[i] != tc.want[i] {
+				t.Errorf("AddressList(%q)[%v] got: %+v, want: %+v", tc.header, i, *got[i], tc.want[i])
+			}
+		}
+	}
+	if _, err := e.AddressList("Bcc"); err == nil {
+		t.Error("AddressList(\"Bcc\") should have returned err, got nil")
+	}
+
+	// Repairs are recorded while parsing, calls do not record them again
+	for _, header := range []string{"Cc", "From"} {
+		warnings := 0
+		for _, perr := range e.Errors {
+			if perr.Name == ErrorMalformedHeader && strings.HasPrefix(perr.Detail, header) {
+				warnings++
+			}
+		}
+		if warnings != 1 {
+			t.Errorf("Got %v warnings for %s header, want 1", warnings, header)
+		}
+	}
+}
+
+func TestStripComments(t *testing.T) {
+	testCases := []struct {
+		input, want string
+	}{
+		{"a@b.com", "a@b.com"},
+		{"a@b.com (Name)", "a@b.com  "},
+		{"<a(x)@b.com>", "<a@b.com>"},
+		{"(a (nested) comment)a@b.com", " a@b.com"},
+		{`"Quoted (not a comment)" <a@b.com>`, `"Quoted (not a comment)" <a@b.com>`},
+		{`(escaped \) paren)a@b.com`, " a@b.com"},
+	}
+	for _, tc := range testCases {
+		if got := stripComments(tc.input); got != tc.want {
+			t.Errorf("stripComments(%q) got: %q, want: %q", tc.input, got, tc.want)
+		}
+	}
+}
+
+func TestEnvelopeAllAttachments(t *testing.T) {
+	raw := "From: alice@example.com\r\n" +
+		"Content-Type: multipart/mixed; boundary=Outer\r\n" +
+		"\r\n" +
+		"--Outer\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"See the forwarded message.\r\n" +
+		"--Outer\r\n" +
+		"Content-Type: text/plain; name=outer.txt\r\n" +
+		"Content-Disposition: attachment; filename=outer.txt\r\n" +
+		"\r\n" +
+		"Outer attachment\r\n" +
+		"--Outer\r\n" +
+		"Content-Type: message/rfc822\r\n" +
+		"\r\n" +
+		"From: bob@example.com\r\n" +
+		"Content-Type: multipart/mixed; boundary=Inner\r\n" +
+		"\r\n" +
+		"--Inner\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"Original message.\r\n" +
+		"--Inner\r\n" +
+		"Content-Type: text/plain; name=inner.txt\r\n" +
+		"Content-Disposition: attachment; filename=inner.txt\r\n" +
+		"\r\n" +
+		"Inner attachment\r\n" +
+		"--Inner--\r\n" +
+		"--Outer--\r\n"
+	e, err := ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+	if len(e.Attachments) != 1 {
+		t.Fatalf("Got %v Attachments, want 1", len(e.Attachments))
+	}
+
+	all := e.AllAttachments()
+	if len(all) != 2 {
+		t.Fatalf("Got %v AllAttachments, want 2", len(all))
+	}
+	testCases := []struct {
+		fileName, content string
+		depth             int
+	}{
+		{"outer.txt", "Outer attachment", 1},
+		// Inner root is parented by the message/rfc822 part
+		{"inner.txt", "Inner attachment", 3},
+	}
+	for i, tc := range testCases {
+		p := all[i]
+		if p.FileName != tc.fileName {
+			t.Errorf("AllAttachments()[%v].FileName == %q, want: %q", i, p.FileName, tc.fileName)
+		}
+		if ok, err := contentEqualsString(p, tc.content); !ok {
+			t.Errorf("AllAttachments()[%v]: %v", i, err)
+		}
+		if got := p.Depth(); got != tc.depth {
+			t.Errorf("AllAttachments()[%v].Depth() == %v, want: %v", i, got, tc.depth)
+		}
+	}
+
+	root := all[1]
+	for root.Parent != nil {
+		root = root.Parent
+	}
+	if root != e.Root {
+		t.Error("Parent links of an embedded attachment should lead to the outer Root")
+	}
+}
+
+func TestEnvelopeAllAttachmentsParserOptions(t *testing.T) {
+	raw := "From: alice@example.com\r\n" +
+		"Content-Type: multipart/mixed; boundary=Outer\r\n" +
+		"\r\n" +
+		"--Outer\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"See the forwarded message.\r\n" +
+		"--Outer\r\n" +
+		"Content-Type: message/rfc822\r\n" +
+		"\r\n" +
+		"From: bob@example.com\r\n" +
+		"Content-Type: multipart/mixed; boundary=Inner\r\n" +
+		"\r\n" +
+		"--Inner\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"Original message.\r\n" +
+		"--Inner\r\n" +
+		"Content-Type: application/octet-stream\r\n" +
+		"Content-Disposition: attachment; filename=\"caf\xe9.bin\"\r\n" +
+		"\r\n" +
+		"Inner attachment data\r\n" +
+		"--Inner--\r\n" +
+		"--Outer--\r\n"
+	p := &Parser{InMemoryThreshold: 16, RawHeaderCharset: "iso-8859-1"}
+	e, err := p.ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+	defer e.Cleanup()
+
+	// The message/rfc822 part is spilled, leaving its Content nil
+	all := e.AllAttachments()
+	if len(all) != 1 {
+		t.Fatalf("Got %v AllAttachments, want 1", len(all))
+	}
+	a := all[0]
+	if got, want := a.FileName, "caf\u00e9.bin"; got != want {
+		t.Errorf("FileName got: %q, want: %q", got, want)
+	}
+	if ok, err := contentEqualsString(a, "Inner attachment data"); !ok {
+		t.Error(err)
+	}
+	if a.TempPath == "" {
+		t.Fatal("Embedded attachment should have been spilled")
+	}
+
+	// Embedded messages are parsed once, repeated calls return the same parts
+	temps := len(e.opts.tempPaths)
+	if all = e.AllAttachments(); len(all) != 1 || all[0] != a {
+		t.Error("AllAttachments() should return the same parts on each call")
+	}
+	if got := len(e.opts.tempPaths); got != temps {
+		t.Errorf("Got %v temporary files after a repeated call, want %v", got, temps)
+	}
+
+	path := a.TempPath
+	if err := e.Cleanup(); err != nil {
+		t.Fatal("Cleanup() error:", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Temporary file %q still exists after Cleanup(), err: %v", path, err)
+	}
+	if a.TempPath != "" {
+		t.Errorf("TempPath got: %q after Cleanup(), want empty", a.TempPath)
+	}
+}
+
+func TestEnvelopeWalk(t *testing.T) {
+	r := openTestData("mail", "html-mime-inline.raw")
+	e, err := ReadEnvelope(r)
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+
+	var want, got []*Part
+	_ = e.Root.Walk(func(p *Part) error {
+		want = append(want, p)
+		return nil
+	})
+	if err := e.Walk(func(p *Part) error {
+		got = append(got, p)
+		return nil
+	}); err != nil {
+		t.Fatal("Walk returned error:", err)
+	}
+	if len(want) < 2 {
+		t.Fatalf("Root.Walk visited %v parts, want a multipart message", len(want))
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Walk visited %v parts, want %v", len(got), len(want))
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("Walk part %v got: %q, want: %q", i, got[i].ContentType, want[i].ContentType)
+		}
+	}
+
+	if err := (&Envelope{}).Walk(func(*Part) error { return errors.New("called") }); err != nil {
+		t.Errorf("Walk of empty Envelope returned error: %v", err)
+	}
+}
@@ -1,25 +1,105 @@
 package enmime
 
 import (
+	"errors"
 	"fmt"
 )
 
+// ErrEmptyMessage is returned when the input to be parsed contains no data at all.
+var ErrEmptyMessage = errors.New("empty message")
+
+// Names of the errors enmime may report, these are stable and may be compared against Error.Name.
+const (
+	ErrorMalformedHeader          = "Malformed Header"
+	ErrorMissingBoundary          = "Missing Boundary"
+	ErrorMissingContentType       = "Missing Content-Type"
+	ErrorCharsetConversion        = "Character Set Conversion"
+	ErrorContentEncoding          = "Content Encoding"
+	ErrorPlainTextFromHTML        = "Plain Text from HTML"
+	ErrorLimitExceeded            = "Limit Exceeded"
+	ErrorInternal                 = "Internal Error"
+	ErrorExcessiveNesting         = "Excessive Nesting"
+	ErrorHeaderTooLong            = "Header Too Long"
+	ErrorBoundaryRecovered        = "Boundary Recovered"
+	ErrorEncodedMultipart         = "Encoded Multipart"
+	ErrorTruncatedMessage         = "Truncated Message"
+	ErrorMissingBoundaryParameter = "Missing Boundary Parameter"
+	ErrorNonStandardContinuation  = "Non-standard Continuation"
+	ErrorContentTypeMismatch      = "Content-Type Mismatch"
+)
+
+// ErrorCode identifies the type of an Error, it corresponds to Error.Name but is suitable for use
+// in a switch statement.  Codes are stable, new codes are only ever added to the end of the list.
+type ErrorCode int
+
+// Codes of the errors enmime may report, see the names above.  ErrCodeUnknown is the zero value,
+// used for Errors not created by enmime.
+const (
+	ErrCodeUnknown ErrorCode = iota
+	ErrCodeMalformedHeader
+	ErrCodeMissingBoundary
+	ErrCodeMissingContentType
+	ErrCodeCharsetConversion
+	ErrCodeContentEncoding
+	ErrCodePlainTextFromHTML
+	ErrCodeLimitExceeded
+	ErrCodeInternal
+	ErrCodeExcessiveNesting
+	ErrCodeHeaderTooLong
+	ErrCodeBoundaryRecovered
+	ErrCodeEncodedMultipart
+	ErrCodeTruncatedMessage
+	ErrCodeMissingBoundaryParameter
+	ErrCodeNonStandardContinuation
+	ErrCodeContentTypeMismatch
+)
+
+// errorCodes maps each error name to its code.
+var errorCodes = map[string]ErrorCode{
+	ErrorMalformedHeader:          ErrCodeMalformedHeader,
+	ErrorMissingBoundary:          ErrCodeMissingBoundary,
+	ErrorMissingContentType:       ErrCodeMissingContentType,
+	ErrorCharsetConversion:        ErrCodeCharsetConversion,
+	ErrorContentEncoding:          ErrCodeContentEncoding,
+	ErrorPlainTextFromHTML:        ErrCodePlainTextFromHTML,
+	ErrorLimitExceeded:            ErrCodeLimitExceeded,
+	ErrorInternal:                 ErrCodeInternal,
+	ErrorExcessiveNesting:         ErrCodeExcessiveNesting,
+	ErrorHeaderTooLong:            ErrCodeHeaderTooLong,
+	ErrorBoundaryRecovered:        ErrCodeBoundaryRecovered,
+	ErrorEncodedMultipart:         ErrCodeEncodedMultipart,
+	ErrorTruncatedMessage:         ErrCodeTruncatedMessage,
+	ErrorMissingBoundaryParameter: ErrCodeMissingBoundaryParameter,
+	ErrorNonStandardContinuation:  ErrCodeNonStandardContinuation,
+	ErrorContentTypeMismatch:      ErrCodeContentTypeMismatch,
+}
+
 type errorName string
 
 const (
-	errorMalformedHeader    errorName = "Malformed Header"
-	errorMissingBoundary    errorName = "Missing Boundary"
-	errorMissingContentType errorName = "Missing Content-Type"
-	errorCharsetConversion  errorName = "Character Set Conversion"
-	errorContentEncoding    errorName = "Content Encoding"
-	errorPlainTextFromHTML  errorName = "Plain Text from HTML"
+	errorMalformedHeader          errorName = ErrorMalformedHeader
+	errorMissingBoundary          errorName = ErrorMissingBoundary
+	errorMissingContentType       errorName = ErrorMissingContentType
+	errorCharsetConversion        errorName = ErrorCharsetConversion
+	errorContentEncoding          errorName = ErrorContentEncoding
+	errorPlainTextFromHTML        errorName = ErrorPlainTextFromHTML
+	errorLimitExceeded            errorName = ErrorLimitExceeded
+	errorExcessiveNesting         errorName = ErrorExcessiveNesting
+	errorHeaderTooLong            errorName = ErrorHeaderTooLong
+	errorBoundaryRecovered        errorName = ErrorBoundaryRecovered
+	errorEncodedMultipart         errorName = ErrorEncodedMultipart
+	errorTruncatedMessage         errorName = ErrorTruncatedMessage
+	errorMissingBoundaryParameter errorName = ErrorMissingBoundaryParameter
+	errorNonStandardContinuation  errorName = ErrorNonStandardContinuation
+	errorContentTypeMismatch      errorName = ErrorContentTypeMismatch
 )
 
 // Error describes an error encountered while parsing.
 type Error struct {
-	Name   string // The name or type of error encountered
-	Detail string // Additional detail about the cause of the error, if available
-	Severe bool   // Indicates that a portion of the message was lost during parsing
+	Name   string    // The name or type of error encountered
+	Detail string    // Additional detail about the cause of the error, if available
+	Severe bool      // Indicates that a portion of the message was lost during parsing
+	Code   ErrorCode // The code corresponding to Name
 }
 
 // String formats the enmime.Error as a string
@@ -31,6 +111,12 @@ func (e *Error) String() string {
 	return fmt.Sprintf("[%s] %s: %s", sev, e.Name, e.Detail)
 }
 
+// Error implements the error interface, allowing an enmime.Error to be returned by Parser in strict
+// mode
+func (e *Error) Error() string {
+	return e.String()
+}
+
 // addWarning builds a severe Error and appends to the Part error slice
 func (p *Part) addError(name errorName, detailFmt string, args ...interface{}) {
 	p.Errors = append(
@@ -39,6 +125,7 @@ func (p *Part) addError(name errorName, detailFmt string, args ...interface{}) {
 			string(name),
 			fmt.Sprintf(detailFmt, args...),
 			true,
+			errorCodes[string(name)],
 		})
 }
 
@@ -50,5 +137,6 @@ func (p *Part) addWarning(name errorName, detailFmt string, args ...interface{})
 			string(name),
 			fmt.Sprintf(detailFmt, args...),
 			false,
+			errorCodes[string(name)],
 		})
 }
@@ -28,6 +28,20 @@ func TestErrorStringConversion(t *testing.T) {
 	}
 }
 
+func TestErrorImplementsError(t *testing.T) {
+	var err error = &Error{
+		Name:   "ErrorName",
+		Detail: "Error Details",
+		Severe: true,
+	}
+
+	want := "[E] ErrorName: Error Details"
+	got := err.Error()
+	if got != want {
+		t.Error("got:", got, "want:", want)
+	}
+}
+
 func TestErrorAddError(t *testing.T) {
 	p := &Part{}
 	p.addError(errorMalformedHeader, "1 %v %q", 2, "three")
@@ -78,7 +92,7 @@ func TestErrorEnvelopeWarnings(t *testing.T) {
 		perror   errorName
 	}{
 		{"bad-final-boundary.raw", errorMissingBoundary},
-		{"bad-header-wrap.raw", errorMalformedHeader},
+		{"bad-header-wrap.raw", errorNonStandardContinuation},
 		{"html-only-inline.raw", errorPlainTextFromHTML},
 		{"missing-content-type.raw", errorMissingContentType},
 		{"missing-content-type2.raw", errorMissingContentType},
@@ -124,3 +138,55 @@ func TestErrorEnvelopeWarnings(t *testing.T) {
 		}
 	}
 }
+
+func TestErrorExportedNames(t *testing.T) {
+	msg := openTestData("low-quality", "bad-final-boundary.raw")
+	e, err := ReadEnvelope(msg)
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+	if len(e.Errors) == 0 {
+		t.Fatal("Got 0 warnings, expected at least one")
+	}
+
+	got := e.Errors[0].Name
+	if got != ErrorMissingBoundary {
+		t.Errorf("e.Errors[0].Name == %q, want: %q", got, ErrorMissingBoundary)
+	}
+}
+
+func TestErrorCodes(t *testing.T) {
+	msg := openTestData("low-quality", "bad-final-boundary.raw")
+	e, err := ReadEnvelope(msg)
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+	if len(e.Errors) == 0 {
+		t.Fatal("Got 0 warnings, expected at least one")
+	}
+	if got := e.Errors[0].Code; got != ErrCodeMissingBoundary {
+		t.Errorf("e.Errors[0].Code == %v, want: %v", got, ErrCodeMissingBoundary)
+	}
+
+	p := &Part{}
+	p.addError(errorTruncatedMessage, "detail")
+	p.addWarning(errorName("Unlisted"), "detail")
+	if got := p.Errors[0].Code; got != ErrCodeTruncatedMessage {
+		t.Errorf("addError() Code == %v, want: %v", got, ErrCodeTruncatedMessage)
+	}
+	if got := p.Errors[1].Code; got != ErrCodeUnknown {
+		t.Errorf("addWarning() with unlisted name Code == %v, want: %v", got, ErrCodeUnknown)
+	}
+
+	// Every code must be distinct
+	seen := make(map[ErrorCode]string)
+	for name, code := range errorCodes {
+		if code == ErrCodeUnknown {
+			t.Errorf("Name %q has code ErrCodeUnknown", name)
+		}
+		if other, ok := seen[code]; ok {
+			t.Errorf("Names %q and %q share code %v", name, other, code)
+		}
+		seen[code] = name
+	}
+}
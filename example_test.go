@@ -153,8 +153,8 @@ hello again!
 	// Attachment 1 X-Comment: "part3"
 	// Part 3 X-Comment: "part3"
 	// Part 3 Content: "hello again!"
-	// [W] Malformed Header: Continued line "filename=hi.txt" was not indented
+	// [W] Non-standard Continuation: Continued line "filename=hi.txt" was not indented
 	//
 	// Envelope errors:
-	// [W] Malformed Header: Continued line "filename=hi.txt" was not indented
+	// [W] Non-standard Continuation: Continued line "filename=hi.txt" was not indented
 }
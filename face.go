@@ -0,0 +1,39 @@
+package enmime
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+)
+
+// pngSignature begins every PNG image.
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// errXFaceUnsupported is returned by FaceImage when the message only has an X-Face header.
+var errXFaceUnsupported = errors.New("X-Face images are not supported, only Face")
+
+// FaceImage returns the image from the Face header of the message, along with its content type.
+// The Face header holds a small base64 encoded PNG, usually a 48x48 avatar of the sender.  The
+// older X-Face header, a compressed monochrome bitmap, is not decoded; an error is returned if it
+// is the only face present.  mail.ErrHeaderNotPresent is returned if there is neither header.
+func (e *Envelope) FaceImage() ([]byte, string, error) {
+	value := e.GetHeader(hnFace)
+	if value == "" {
+		if e.GetHeader(hnXFace) != "" {
+			return nil, "", errXFaceUnsupported
+		}
+		return nil, "", mail.ErrHeaderNotPresent
+	}
+	// Folding leaves whitespace within the base64 text
+	data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(value), ""))
+	if err != nil {
+		return nil, "", fmt.Errorf("Face header is not valid base64: %v", err)
+	}
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, "", fmt.Errorf("Face header does not contain a PNG image")
+	}
+	return data, "image/png", nil
+}
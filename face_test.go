@@ -0,0 +1,66 @@
+package enmime
+
+import (
+	"bytes"
+	"image/png"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestEnvelopeFaceImage(t *testing.T) {
+	// A 1x1 grayscale PNG, folded across several lines
+	raw := "From: user@inbucket.org\r\n" +
+		"Face: iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAAAAAA6fptVAAAACklEQVR4\r\n" +
+		" nGNgAAAAAgABSK+kcQAAAABJRU5ErkJggg==\r\n" +
+		"\r\n" +
+		"Body\r\n"
+	e, err := ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+	data, ctype, err := e.FaceImage()
+	if err != nil {
+		t.Fatal("FaceImage() error:", err)
+	}
+	if ctype != "image/png" {
+		t.Errorf("FaceImage() content type got: %q, want: %q", ctype, "image/png")
+	}
+	if len(data) != 67 || !bytes.HasSuffix(data, []byte("IEND\xae\x42\x60\x82")) {
+		t.Errorf("FaceImage() returned %v bytes: %q", len(data), data)
+	}
+	config, err := png.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal("Decoded face is not a PNG:", err)
+	}
+	if config.Width != 1 || config.Height != 1 {
+		t.Errorf("Decoded face is %vx%v, want 1x1", config.Width, config.Height)
+	}
+}
+
+func TestEnvelopeFaceImageErrors(t *testing.T) {
+	testCases := []struct {
+		name, header string
+		wantErr      error
+	}{
+		{"no face", "", mail.ErrHeaderNotPresent},
+		{"x-face only", "X-Face: \"8,/|6^_b%`Ar\r\n", errXFaceUnsupported},
+		{"invalid base64", "Face: not*base64\r\n", nil},
+		{"not a png", "Face: R0lGODlhAQABAAAAACw=\r\n", nil},
+	}
+	for _, tc := range testCases {
+		raw := "From: user@inbucket.org\r\n" + tc.header + "\r\nBody\r\n"
+		e, err := ReadEnvelope(strings.NewReader(raw))
+		if err != nil {
+			t.Fatal("Failed to parse MIME:", err)
+		}
+		data, _, err := e.FaceImage()
+		if err == nil {
+			t.Errorf("%s: FaceImage() returned %q, want error", tc.name, data)
+			continue
+		}
+		if tc.wantErr != nil && err != tc.wantErr {
+			t.Errorf("%s: FaceImage() error got: %v, want: %v", tc.name, err, tc.wantErr)
+		}
+	}
+}
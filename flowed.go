@@ -0,0 +1,68 @@
+package enmime
+
+import (
+	"bytes"
+	"strings"
+)
+
+// deflow rejoins the soft line breaks of format=flowed text, as described by RFC 3676.  Lines
+// ending in a space are joined with the line that follows, provided both have the same quote
+// depth; when delSp is true the trailing space is deleted as part of the join.  Space-stuffing is
+// removed, and quoted lines are written with their quote depth as a ">" prefix followed by a
+// space.  The line endings of b are preserved.
+func deflow(b []byte, delSp bool) []byte {
+	nl := "\n"
+	if bytes.Contains(b, []byte("\r\n")) {
+		nl = "\r\n"
+	}
+	s := strings.Replace(string(b), "\r\n", "\n", -1)
+	trailingNL := strings.HasSuffix(s, "\n")
+	s = strings.TrimSuffix(s, "\n")
+
+	var out []string
+	var para []string
+	depth := 0
+	flush := func() {
+		if para == nil {
+			return
+		}
+		prefix := ""
+		if depth > 0 {
+			prefix = strings.Repeat(">", depth) + " "
+		}
+		out = append(out, prefix+strings.Join(para, ""))
+		para = nil
+	}
+
+	for _, line := range strings.Split(s, "\n") {
+		lineDepth := 0
+		for lineDepth < len(line) && line[lineDepth] == '>' {
+			lineDepth++
+		}
+		text := line[lineDepth:]
+		// Remove space-stuffing
+		text = strings.TrimPrefix(text, " ")
+		// The signature separator is never flowed
+		flowed := strings.HasSuffix(text, " ") && text != "-- "
+
+		if para != nil && lineDepth != depth {
+			// A change in quote depth ends the paragraph, even after a soft break
+			flush()
+		}
+		depth = lineDepth
+		if flowed && delSp {
+			text = text[:len(text)-1]
+		}
+		para = append(para, text)
+		if !flowed {
+			flush()
+		}
+	}
+	flush()
+
+	result := strings.Join(out, nl)
+	if trailingNL {
+		result += nl
+	}
+	return []byte(result)
+}
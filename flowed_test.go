@@ -0,0 +1,137 @@
+package enmime
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDeflow(t *testing.T) {
+	testCases := []struct {
+		name  string
+		input string
+		delSp bool
+		want  string
+	}{
+		{
+			name:  "soft breaks",
+			input: "The quick brown \nfox jumps over \nthe lazy dog.\nNew line.\n",
+			want:  "The quick brown fox jumps over the lazy dog.\nNew line.\n",
+		},
+		{
+			name:  "delsp",
+			input: "Long-\nword and spa \nced\n",
+			delSp: true,
+			want:  "Long-\nword and spaced\n",
+		},
+		{
+			name:  "crlf",
+			input: "one \r\ntwo\r\nthree\r\n",
+			want:  "one two\r\nthree\r\n",
+		},
+		{
+			name:  "space stuffing",
+			input: " From me\n >not quoted\n",
+			want:  "From me\n>not quoted\n",
+		},
+		{
+			name:  "quotes",
+			input: "> quoted \n> text\n>> deeper \n>> quote\nreply\n",
+			want:  "> quoted text\n>> deeper quote\nreply\n",
+		},
+		{
+			name:  "quote depth change ends paragraph",
+			input: "> dangling \nunquoted\n",
+			want:  "> dangling \nunquoted\n",
+		},
+		{
+			name:  "signature separator",
+			input: "Bye\n-- \nAlice\n",
+			want:  "Bye\n-- \nAlice\n",
+		},
+		{
+			name:  "no trailing newline",
+			input: "one \ntwo",
+			want:  "one two",
+		},
+	}
+	for _, tc := range testCases {
+		got := string(deflow([]byte(tc.input), tc.delSp))
+		if got != tc.want {
+			t.Errorf("%s: deflow(%q)\ngot : %q\nwant: %q", tc.name, tc.input, got, tc.want)
+		}
+	}
+}
+
+func TestFlowedEnvelopeText(t *testing.T) {
+	raw := "From: alice@example.com\r\n" +
+		"Content-Type: text/plain; charset=utf-8; format=flowed; delsp=no\r\n" +
+		"\r\n" +
+		"This paragraph was wrapped by the \r\n" +
+		"sending mail client.\r\n" +
+		"\r\n" +
+		"> And this quote \r\n" +
+		"> was too.\r\n"
+	e, err := ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "This paragraph was wrapped by the sending mail client.\r\n" +
+		"\r\n" +
+		"> And this quote was too.\r\n"
+	if e.Text != want {
+		t.Errorf("Text\ngot : %q\nwant: %q", e.Text, want)
+	}
+}
+
+func TestFlowedAttachment(t *testing.T) {
+	flowed := "Wrapped \r\nline"
+	raw := "From: alice@example.com\r\n" +
+		"Content-Type: multipart/mixed; boundary=Enmime\r\n" +
+		"\r\n" +
+		"--Enmime\r\n" +
+		"Content-Type: text/plain; format=flowed\r\n" +
+		"\r\n" +
+		flowed + "\r\n" +
+		"--Enmime\r\n" +
+		"Content-Type: text/plain; format=flowed\r\n" +
+		"Content-Disposition: attachment; filename=notes.txt\r\n" +
+		"\r\n" +
+		flowed + "\r\n" +
+		"--Enmime--\r\n"
+	e, err := ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Wrapped line"; e.Text != want {
+		t.Errorf("Text got: %q, want: %q", e.Text, want)
+	}
+	if len(e.Attachments) != 1 {
+		t.Fatalf("Got %v attachments, want 1", len(e.Attachments))
+	}
+	if got := string(e.Attachments[0].Content); got != flowed {
+		t.Errorf("Attachment Content got: %q, want: %q", got, flowed)
+	}
+
+	// The deflowed body is no longer declared as flowed once encoded
+	buf := new(bytes.Buffer)
+	if err := e.Encode(buf); err != nil {
+		t.Fatal("Encode() error:", err)
+	}
+	got, err := ReadEnvelope(buf)
+	if err != nil {
+		t.Fatal("Failed to parse encoded MIME:", err)
+	}
+	if got.Text != e.Text {
+		t.Errorf("Encoded Text got: %q, want: %q", got.Text, e.Text)
+	}
+	body := got.Root.FirstChild
+	if ctype := body.Header.Get(hnContentType); ctype != "text/plain" {
+		t.Errorf("Encoded body Content-Type got: %q, want: %q", ctype, "text/plain")
+	}
+	a := got.Attachments[0]
+	if string(a.Content) != flowed || a.ContentTypeParams()[hpFormat] != "flowed" {
+		t.Errorf("Encoded attachment got: %q with params %v, want it unchanged",
+			a.Content, a.ContentTypeParams())
+	}
+}
@@ -0,0 +1,40 @@
+//go:build go1.18
+// +build go1.18
+
+package enmime
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func FuzzReadEnvelope(f *testing.F) {
+	for _, dir := range []string{"mail", "low-quality"} {
+		files, err := filepath.Glob(filepath.Join("testdata", dir, "*.raw"))
+		if err != nil {
+			f.Fatal(err)
+		}
+		for _, file := range files {
+			b, err := ioutil.ReadFile(file)
+			if err != nil {
+				f.Fatal(err)
+			}
+			f.Add(b)
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		e, err := ReadEnvelope(bytes.NewReader(data))
+		if err != nil {
+			if perr, ok := err.(*Error); ok && perr.Name == ErrorInternal {
+				t.Fatalf("ReadEnvelope panicked: %v", err)
+			}
+			return
+		}
+		if e == nil {
+			t.Fatal("ReadEnvelope returned a nil Envelope without an error")
+		}
+	})
+}
@@ -6,9 +6,14 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"mime"
+	"mime/quotedprintable"
 	"net/textproto"
+	"regexp"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 const (
@@ -17,27 +22,69 @@ const (
 	cdInline     = "inline"
 
 	// Standard MIME content types
-	ctAppOctetStream  = "application/octet-stream"
-	ctMultipartAltern = "multipart/alternative"
-	ctMultipartPrefix = "multipart/"
-	ctTextPlain       = "text/plain"
-	ctTextHTML        = "text/html"
+	ctAppOctetStream        = "application/octet-stream"
+	ctAppPKCS7Mime          = "application/pkcs7-mime"
+	ctAppXPKCS7Mime         = "application/x-pkcs7-mime"
+	ctImagePrefix           = "image/"
+	ctMessageDeliveryStatus = "message/delivery-status"
+	ctMessageRFC822         = "message/rfc822"
+	ctMultipartAltern       = "multipart/alternative"
+	ctMultipartEncrypted    = "multipart/encrypted"
+	ctMultipartMixed        = "multipart/mixed"
+	ctMultipartPrefix       = "multipart/"
+	ctMultipartRelated      = "multipart/related"
+	ctMultipartReport       = "multipart/report"
+	ctMultipartSigned       = "multipart/signed"
+	ctTextPrefix            = "text/"
+	ctTextPlain             = "text/plain"
+	ctTextHTML              = "text/html"
+	ctTextVCard             = "text/vcard"
+	ctTextXVCard            = "text/x-vcard"
+	ctTextDirectory         = "text/directory"
+	ctTextRFC822Headers     = "text/rfc822-headers"
 
 	// Standard MIME header names
-	hnContentDisposition = "Content-Disposition"
-	hnContentEncoding    = "Content-Transfer-Encoding"
-	hnContentType        = "Content-Type"
+	hnAuthenticationResults = "Authentication-Results"
+	hnComments              = "Comments"
+	hnContentDescription    = "Content-Description"
+	hnContentDisposition    = "Content-Disposition"
+	hnContentEncoding       = "Content-Transfer-Encoding"
+	hnContentID             = "Content-ID"
+	hnContentLanguage       = "Content-Language"
+	hnContentLocation       = "Content-Location"
+	hnContentType           = "Content-Type"
+	hnFace                  = "Face"
+	hnInReplyTo             = "In-Reply-To"
+	hnKeywords              = "Keywords"
+	hnMessageID             = "Message-ID"
+	hnReferences            = "References"
+	hnSubject               = "Subject"
+	hnXFace                 = "X-Face"
 
 	// Standard MIME header parameters
-	hpBoundary = "boundary"
-	hpCharset  = "charset"
-	hpFile     = "file"
-	hpFilename = "filename"
-	hpName     = "name"
+	hpBoundary  = "boundary"
+	hpCharset   = "charset"
+	hpFile      = "file"
+	hpFilename  = "filename"
+	hpFormat    = "format"
+	hpDelSp     = "delsp"
+	hpName      = "name"
+	hpProtocol  = "protocol"
+	hpSMIMEType = "smime-type"
 )
 
 var errEmptyHeaderBlock = errors.New("empty header block")
 
+// errLimitExceeded is returned internally to stop parsing once a Parser limit has been exceeded
+var errLimitExceeded = errors.New("parser limit exceeded")
+
+// singleQuotedParamRegexp matches a non-extended media type parameter with a value wrapped in
+// single quotes
+var singleQuotedParamRegexp = regexp.MustCompile(`(;\s*[^\s=;*"]+\s*=\s*)'([^'";]*)'(\s*(?:;|$))`)
+
+// unquotedParamRegexp matches a non-extended media type parameter with an unquoted value
+var unquotedParamRegexp = regexp.MustCompile(`(;\s*[^\s=;*"]+\s*=\s*)([^\s;"][^;"]*)`)
+
 // AddressHeaders is the set of SMTP headers that contain email addresses, used by
 // Envelope.AddressList().  Key characters must be all lowercase.
 var AddressHeaders = map[string]bool{
@@ -46,6 +93,7 @@ var AddressHeaders = map[string]bool{
 	"delivered-to":    true,
 	"from":            true,
 	"reply-to":        true,
+	"return-path":     true,
 	"to":              true,
 	"sender":          true,
 	"resent-bcc":      true,
@@ -71,11 +119,14 @@ func debug(format string, args ...interface{}) {
 
 // readHeader reads a block of SMTP or MIME headers and returns a textproto.MIMEHeader.
 // Header parse warnings & errors will be added to p.Errors, io errors will be returned directly.
-func readHeader(r *bufio.Reader, p *Part) (textproto.MIMEHeader, error) {
-	// buf holds the massaged output for textproto.Reader.ReadMIMEHeader()
+func readHeader(r *bufio.Reader, p *Part, opts *Parser) (textproto.MIMEHeader, error) {
+	// buf holds the massaged output for parseHeaderLines()
 	buf := &bytes.Buffer{}
 	tp := textproto.NewReader(r)
 	firstHeader := true
+	var name []byte       // Name of the current header, for warnings
+	var continuations int // Count of continuation lines for the current header
+	var fields []rawHeaderField
 	for {
 		// Pull out each line of the headers as a temporary slice s
 		s, err := tp.ReadLineBytes()
@@ -90,6 +141,26 @@ func readHeader(r *bufio.Reader, p *Part) (textproto.MIMEHeader, error) {
 		}
 		firstColon := bytes.IndexByte(s, ':')
 		firstSpace := bytes.IndexAny(s, " \t\n\r")
+		if firstSpace == 0 || (firstColon == -1 && len(s) > 0) {
+			// Continuation line, discard if the limit has been exceeded
+			continuations++
+			max := opts.MaxHeaderContinuations
+			if max > 0 && continuations > max {
+				if continuations == max+1 {
+					p.addWarning(
+						errorMalformedHeader,
+						"Header %q exceeded %v continuation lines, remainder discarded",
+						name,
+						max)
+				}
+				continue
+			}
+		}
+		if len(fields) > 0 && len(s) > 0 && (firstSpace == 0 || firstColon == -1) {
+			// Continuation of the current field, retain the original line
+			f := &fields[len(fields)-1]
+			f.raw = append(append(f.raw, s...), '\r', '\n')
+		}
 		if firstSpace == 0 {
 			// Starts with space: continuation
 			buf.WriteByte(' ')
@@ -107,16 +178,25 @@ func readHeader(r *bufio.Reader, p *Part) (textproto.MIMEHeader, error) {
 				// New Header line, end the previous
 				buf.Write([]byte{'\r', '\n'})
 			}
+			fields = append(fields, rawHeaderField{
+				name: textproto.CanonicalMIMEHeaderKey(string(textproto.TrimBytes(s[:firstColon]))),
+				raw:  append(append([]byte(nil), s...), '\r', '\n'),
+			})
 			s = textproto.TrimBytes(s)
 			buf.Write(s)
 			firstHeader = false
+			name = append(name[:0], s[:firstColon]...)
+			continuations = 0
 		} else {
 			// No colon: potential non-indented continuation
-			if len(s) > 0 {
+			if len(s) > 0 && firstHeader {
+				// Nothing to continue, discard the line
+				p.addWarning(errorMalformedHeader, "Header line %q lacked a colon, discarded", s)
+			} else if len(s) > 0 {
 				// Attempt to detect and repair a non-indented continuation of previous line
 				buf.WriteByte(' ')
 				buf.Write(s)
-				p.addWarning(errorMalformedHeader, "Continued line %q was not indented", s)
+				p.addWarning(errorNonStandardContinuation, "Continued line %q was not indented", s)
 			} else {
 				// Empty line, finish header parsing
 				buf.Write([]byte{'\r', '\n'})
@@ -124,26 +204,327 @@ func readHeader(r *bufio.Reader, p *Part) (textproto.MIMEHeader, error) {
 			}
 		}
 	}
-	buf.Write([]byte{'\r', '\n'})
-	tr := textproto.NewReader(bufio.NewReader(buf))
-	header, err := tr.ReadMIMEHeader()
-	return header, err
+	header := parseHeaderLines(buf.Bytes())
+	checkHeaderLength(fields, p, opts)
+
+	// Repair parameter values quoted with single quotes
+	for _, name := range []string{hnContentType, hnContentDisposition} {
+		if value, fixed := fixSingleQuotedParams(header.Get(name)); fixed {
+			p.addWarning(
+				errorMalformedHeader,
+				"%s parameter values in %q were quoted with single quotes",
+				name,
+				header.Get(name))
+			header.Set(name, value)
+		}
+	}
+
+	// Retain the original header lines and values, allowing unmodified fields to be re-encoded
+	// verbatim
+	p.rawHeader = fields
+	p.origHeader = make(textproto.MIMEHeader, len(header))
+	for k, v := range header {
+		p.origHeader[k] = append([]string(nil), v...)
+	}
+	return header, nil
+}
+
+// checkHeaderLength adds a warning to p for each header field containing a line longer than
+// opts.MaxHeaderLength, in the order the fields appeared.  Lines are measured as they appeared in
+// the input, excluding the line ending, so a long value that was correctly folded is accepted.
+func checkHeaderLength(fields []rawHeaderField, p *Part, opts *Parser) {
+	max := opts.MaxHeaderLength
+	if max <= 0 {
+		return
+	}
+	for _, f := range fields {
+		longest := 0
+		for _, line := range bytes.Split(f.raw, []byte("\n")) {
+			if n := len(bytes.TrimSuffix(line, []byte("\r"))); n > longest {
+				longest = n
+			}
+		}
+		if longest > max {
+			p.addWarning(
+				errorHeaderTooLong,
+				"Header %q has a line %v bytes long, exceeding %v",
+				f.name,
+				longest,
+				max)
+		}
+	}
+}
+
+// rawHeaderField holds the original, folded lines of a single header field.
+type rawHeaderField struct {
+	name string // Canonical header name
+	raw  []byte // Original lines, including CRLF line endings
+}
+
+// parseHeaderLines builds a textproto.MIMEHeader from CRLF separated, unfolded "Name: value" lines.
+// Unlike textproto.Reader.ReadMIMEHeader, it tolerates whitespace and other invalid characters in
+// header names, and runs in linear time regardless of line length.
+func parseHeaderLines(b []byte) textproto.MIMEHeader {
+	header := make(textproto.MIMEHeader)
+	for len(b) > 0 {
+		var line []byte
+		if i := bytes.Index(b, []byte{'\r', '\n'}); i != -1 {
+			line, b = b[:i], b[i+2:]
+		} else {
+			line, b = b, nil
+		}
+		i := bytes.IndexByte(line, ':')
+		if i < 1 {
+			continue
+		}
+		key := textproto.CanonicalMIMEHeaderKey(string(textproto.TrimBytes(line[:i])))
+		header.Add(key, string(textproto.TrimBytes(line[i+1:])))
+	}
+	return header
+}
+
+// fixSingleQuotedParams rewrites media type parameter values that are wrapped in single quotes,
+// such as charset='utf-8', to use double quotes instead.  RFC 2231 extended parameters, ie
+// name*=charset'lang'value, legitimately contain single quotes and are left untouched.  Returns
+// true if any changes were made.
+func fixSingleQuotedParams(value string) (string, bool) {
+	fixed := false
+	for {
+		// Each pass may skip parameters that share a ';' with a replaced neighbor
+		next := singleQuotedParamRegexp.ReplaceAllString(value, `${1}"${2}"${3}`)
+		if next == value {
+			return value, fixed
+		}
+		value = next
+		fixed = true
+	}
+}
+
+// quoteParamValues wraps the unquoted parameter values of a media type in double quotes, so that
+// values containing special characters, such as boundary=----=_Part_0, may be parsed.  Text within
+// quoted values that resembles a parameter, as in name="x; y=z", is left untouched.
+func quoteParamValues(ctype string) string {
+	matches := unquotedParamRegexp.FindAllStringSubmatchIndex(ctype, -1)
+	if matches == nil {
+		return ctype
+	}
+	var b bytes.Buffer
+	last, pos := 0, 0
+	quoted, escaped := false, false
+	for _, m := range matches {
+		// Track quoted strings up to the start of this match, as splitUnquoted does
+		for ; pos < m[0]; pos++ {
+			switch c := ctype[pos]; {
+			case escaped:
+				escaped = false
+			case c == '\\' && quoted:
+				escaped = true
+			case c == '"':
+				quoted = !quoted
+			}
+		}
+		if quoted {
+			continue
+		}
+		value := strings.TrimRight(ctype[m[4]:m[5]], " \t")
+		b.WriteString(ctype[last:m[4]])
+		b.WriteString(`"` + strings.Replace(value, `\`, `\\`, -1) + `"`)
+		last = m[4] + len(value)
+	}
+	b.WriteString(ctype[last:])
+	return b.String()
+}
+
+// rfc2231ParamRegexp matches an RFC 2231 extended parameter, capturing the name, the continuation
+// section if any, and the value.
+var rfc2231ParamRegexp = regexp.MustCompile(`([^\s;="*]+)(\*[0-9]+)?\*\s*=\s*"?([^";\s]*)"?`)
+
+// convertRFC2231Charsets rewrites RFC 2231 extended parameters declaring a charset other than
+// UTF-8 or US-ASCII, ie filename*=iso-8859-1'fr'caf%E9, to use UTF-8.  mime.ParseMediaType only
+// supports those two charsets, and drops parameters in any other.  Parameters that cannot be
+// converted are left untouched.
+func convertRFC2231Charsets(value string) string {
+	if !strings.Contains(value, "*") {
+		return value
+	}
+	charsets := make(map[string]string)
+	return rfc2231ParamRegexp.ReplaceAllStringFunc(value, func(param string) string {
+		m := rfc2231ParamRegexp.FindStringSubmatch(param)
+		name, section, pct := strings.ToLower(m[1]), m[2], m[3]
+		prefix := ""
+		if section == "" || section == "*0" {
+			// The first section declares the charset and language
+			fields := strings.SplitN(pct, "'", 3)
+			if len(fields) != 3 {
+				return param
+			}
+			charsets[name] = strings.ToLower(fields[0])
+			prefix = "utf-8'" + fields[1] + "'"
+			pct = fields[2]
+		}
+		charset := charsets[name]
+		if charset == "" || charset == "utf-8" || charset == "us-ascii" {
+			return param
+		}
+		raw, err := unescapePercent(pct)
+		if err != nil {
+			return param
+		}
+		decoded, err := convertToUTF8String(charset, raw)
+		if err != nil {
+			return param
+		}
+		return m[1] + section + "*=" + prefix + escapePercent(decoded)
+	})
+}
+
+// unescapePercent decodes the %XX escapes of an RFC 2231 extended parameter value.
+func unescapePercent(s string) ([]byte, error) {
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b = append(b, s[i])
+			continue
+		}
+		if i+2 >= len(s) || !isValidHexByte(s[i+1]) || !isValidHexByte(s[i+2]) {
+			return nil, fmt.Errorf("invalid escape in %q", s)
+		}
+		v, _ := strconv.ParseUint(s[i+1:i+3], 16, 8)
+		b = append(b, byte(v))
+		i += 2
+	}
+	return b, nil
+}
+
+// escapePercent encodes s for use as an RFC 2231 extended parameter value, escaping everything
+// but the attribute-chars of RFC 2231.
+func escapePercent(s string) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+			strings.IndexByte("!#$&+-.^_`|~", c) != -1 {
+			buf.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&buf, "%%%02X", c)
+	}
+	return buf.String()
+}
+
+// maxHeaderDecodePasses limits how many layers of RFC 2047 encoding decodeHeader will remove
+const maxHeaderDecodePasses = 3
+
+// encodedWordRegexp matches an RFC 2047 encoded-word
+var encodedWordRegexp = regexp.MustCompile(`=\?[^?\s]+\?[bBqQ]\?[^?\s]*\?=`)
+
+// obsoleteRouteRegexp matches the obsolete source route at the start of an angle-addr, for example
+// the "@a,@b:" in "<@a,@b:user@host>"
+var obsoleteRouteRegexp = regexp.MustCompile(`<\s*@[^<>:"]*:`)
+
+// stripAddressRoutes removes obsolete source routes (RFC 5322 section 4.4) from the address
+// headers of p, leaving the final addr-spec which net/mail is able to parse.
+func (p *Part) stripAddressRoutes() {
+	for name, values := range p.Header {
+		if !AddressHeaders[strings.ToLower(name)] {
+			continue
+		}
+		for i, v := range values {
+			if stripped := obsoleteRouteRegexp.ReplaceAllString(v, "<"); stripped != v {
+				p.addWarning(
+					errorMalformedHeader,
+					"Removed obsolete source route from %s address %q",
+					name,
+					v)
+				values[i] = stripped
+			}
+		}
+	}
 }
 
-// decodeHeader decodes a single line (per RFC 2047) using Golang's mime.WordDecoder
+// decodeHeader decodes a single line (per RFC 2047) using Golang's mime.WordDecoder.  Values that
+// were encoded more than once by buggy forwarding systems are decoded again while the output still
+// contains an encoded-word, up to maxHeaderDecodePasses times.
 func decodeHeader(input string) string {
+	return decodeHeaderCharset(input, "")
+}
+
+// decodeHeaderCharset implements decodeHeader, a value without encoded-words that contains raw
+// 8-bit bytes is converted from rawCharset, see Parser.RawHeaderCharset.
+func decodeHeaderCharset(input, rawCharset string) string {
 	if !strings.Contains(input, "=?") {
 		// Don't scan if there is nothing to do here
-		return input
+		return decodeRawHeader(input, rawCharset)
 	}
 
+	// WordDecoder decodes encoded-words that directly abut literal text, such as
+	// "=?UTF-8?Q?a?=b", appending the literal remainder unchanged.
 	dec := new(mime.WordDecoder)
-	dec.CharsetReader = newCharsetReader
-	header, err := dec.DecodeHeader(input)
+	dec.CharsetReader = charsetReader
+	header := input
+	for i := 0; i < maxHeaderDecodePasses; i++ {
+		output, err := dec.DecodeHeader(header)
+		if err != nil || output == header {
+			break
+		}
+		header = output
+		if !encodedWordRegexp.MatchString(header) {
+			break
+		}
+	}
+	return header
+}
+
+// decodeRawHeader converts a header value containing raw 8-bit bytes to UTF-8 from charset.
+// Values that are already valid UTF-8, or any value when charset is empty, are returned unchanged.
+func decodeRawHeader(input, charset string) string {
+	if charset == "" || utf8.ValidString(input) {
+		return input
+	}
+	output, err := convertToUTF8String(charset, []byte(input))
 	if err != nil {
 		return input
 	}
-	return header
+	return output
+}
+
+// qpSequenceRegexp matches a quoted-printable encoded octet
+var qpSequenceRegexp = regexp.MustCompile(`=[0-9A-Fa-f]{2}`)
+
+// decodeQPSubject detects a Subject header that was quoted-printable encoded without RFC 2047
+// encoded-word syntax, and replaces it with the decoded bytes.  The decoded value is interpreted
+// using Parser.RawHeaderCharset when it is not valid UTF-8.  This is a heuristic: the Subject must
+// contain at least two encoded octets making up at least a fifth of its length.
+func (p *Part) decodeQPSubject() {
+	subject := p.Header.Get(hnSubject)
+	if subject == "" || strings.Contains(subject, "=?") {
+		return
+	}
+	count := len(qpSequenceRegexp.FindAllStringIndex(subject, -1))
+	if count < 2 || count*3*5 < len(subject) {
+		return
+	}
+	decoded, err := ioutil.ReadAll(quotedprintable.NewReader(strings.NewReader(subject)))
+	if err != nil {
+		return
+	}
+	p.addWarning(
+		errorMalformedHeader,
+		"Subject %q was quoted-printable encoded without encoded-word syntax",
+		subject)
+	p.Header.Set(hnSubject, string(decoded))
+}
+
+// EncodeToUTF8Base64 decodes the RFC 2047 encoded-words in a header value and re-encodes them as
+// UTF-8 base64 encoded-words, ie "=?UTF-8?b?...?=", so that the header may be retransmitted with a
+// single known character set.  The input is split into whitespace separated tokens which are
+// rejoined by single spaces.  Only the encoded-words within a token are re-encoded; literal text
+// in the same token, such as the parentheses surrounding an encoded comment or a name directly
+// following a word, is retained as is.  A re-encoded word that would exceed the RFC 2047 limit of
+// 75 characters is split into several space separated encoded-words.  Input without encoded-words
+// is returned unchanged.
+func EncodeToUTF8Base64(input string) string {
+	return decodeToUTF8Base64Header(input)
 }
 
 // decodeToUTF8Base64Header decodes a MIME header per RFC 2047, reencoding to =?utf-8b?
@@ -157,23 +538,12 @@ func decodeToUTF8Base64Header(input string) string {
 	tokens := strings.FieldsFunc(input, isWhiteSpaceRune)
 	output := make([]string, len(tokens), len(tokens))
 	for i, token := range tokens {
-		if len(token) > 4 && strings.Contains(token, "=?") {
-			// Stash parenthesis, they should not be encoded
-			prefix := ""
-			suffix := ""
-			if token[0] == '(' {
-				prefix = "("
-				token = token[1:]
-			}
-			if token[len(token)-1] == ')' {
-				suffix = ")"
-				token = token[:len(token)-1]
-			}
-			// Base64 encode token
-			output[i] = prefix + mime.BEncoding.Encode("UTF-8", decodeHeader(token)) + suffix
-		} else {
-			output[i] = token
-		}
+		// Re-encode only the encoded-words, literal text such as parenthesis or a name directly
+		// following a word is retained as is.  The encoder splits long words to respect the 75
+		// character limit
+		output[i] = encodedWordRegexp.ReplaceAllStringFunc(token, func(word string) string {
+			return mime.BEncoding.Encode("UTF-8", decodeHeader(word))
+		})
 		debug("%v %q %q", i, token, output[i])
 	}
 
@@ -2,6 +2,9 @@ package enmime
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
 	"strings"
 	"testing"
 )
@@ -117,6 +120,25 @@ func TestSpacing(t *testing.T) {
 	}
 }
 
+// Literal text directly adjacent to an encoded-word is preserved
+func TestAdjacentLiteral(t *testing.T) {
+	var testTable = []struct {
+		in, want string
+	}{
+		{"=?UTF-8?Q?a?=b", "ab"},
+		{"=?UTF-8?Q?a?=.", "a."},
+		{"b=?UTF-8?Q?a?=", "ba"},
+		{"=?UTF-8?Q?a?=b =?UTF-8?Q?c?=", "ab c"},
+	}
+
+	for _, tt := range testTable {
+		got := decodeHeader(tt.in)
+		if got != tt.want {
+			t.Errorf("DecodeHeader(%q) == %q, want: %q", tt.in, got, tt.want)
+		}
+	}
+}
+
 // Test some different character sets
 func TestCharsets(t *testing.T) {
 	var testTable = []struct {
@@ -135,22 +157,98 @@ func TestCharsets(t *testing.T) {
 	}
 }
 
-// Test re-encoding to base64
-func TestDecodeToUTF8Base64Header(t *testing.T) {
+// Test decoding of raw 8-bit header values with RawHeaderCharset
+func TestDecodeRawHeaderCharset(t *testing.T) {
+	var testTable = []struct {
+		charset, in, want string
+	}{
+		{"", "Caf\xe9 cr\xe8me", "Caf\xe9 cr\xe8me"},
+		{"iso-8859-1", "Caf\xe9 cr\xe8me", "Caf\u00e9 cr\u00e8me"},
+		{"iso-8859-1", "Caf\u00e9 cr\u00e8me", "Caf\u00e9 cr\u00e8me"},
+		{"iso-8859-1", "plain ascii", "plain ascii"},
+		{"INVALIDcharsetZZZ", "Caf\xe9", "Caf\xe9"},
+	}
+
+	for _, tt := range testTable {
+		got := decodeHeaderCharset(tt.in, tt.charset)
+		if got != tt.want {
+			t.Errorf("DecodeHeader(%q) with charset %q == %q, want: %q",
+				tt.in, tt.charset, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeHeaderEncodingCase(t *testing.T) {
+	var testTable = []struct {
+		in, want string
+	}{
+		{"=?utf-8?b?TWlyb3PFgmF3?=", "Mirosław"},
+		{"=?UTF-8?B?TWlyb3PFgmF3?=", "Mirosław"},
+		{"=?utf-8?q?Miros=C5=82aw?=", "Mirosław"},
+		{"=?Utf-8?Q?Miros=c5=82aw?=", "Mirosław"},
+		{"=?Iso-8859-1?q?caf=e9?=", "café"},
+		{"=?ISO-8859-2?b?sQ==?=", "ą"},
+		{"=?windows-1252?B?gA==?=", "€"},
+	}
+
+	for _, tt := range testTable {
+		got := decodeHeader(tt.in)
+		if got != tt.want {
+			t.Errorf("decodeHeader(%q) == %q, want: %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeHeaderDoubleEncoded(t *testing.T) {
 	var testTable = []struct {
 		in, want string
 	}{
-		{"no encoding", "no encoding"},
-		{"=?utf-8?q?abcABC_=24_=c2=a2_=e2=82=ac?=", "=?UTF-8?b?YWJjQUJDICQgwqIg4oKs?="},
-		{"=?iso-8859-1?q?#=a3_c=a9_r=ae_u=b5?=", "=?UTF-8?b?I8KjIGPCqSBywq4gdcK1?="},
-		{"=?big5?q?=a1=5d_=a1=61_=a1=71?=", "=?UTF-8?b?77yIIO+9myDjgIg=?="},
-		// Must respect separate tokens
-		{"=?UTF-8?Q?Miros=C5=82aw?= <u@h>", "=?UTF-8?b?TWlyb3PFgmF3?= <u@h>"},
-		{"First Last <u@h> (=?iso-8859-1?q?#=a3_c=a9_r=ae_u=b5?=)",
-			"First Last <u@h> (=?UTF-8?b?I8KjIGPCqSBywq4gdcK1?=)"},
+		// Encoded-word, Q encoded again
+		{"=?UTF-8?Q?=3D=3FUTF=2D8=3FB=3FQ2Fmw6kgY3LDqG1l=3F=3D?=", "Café crème"},
+		// Three layers
+		{"=?UTF-8?B?PT9VVEYtOD9RPz0zRD0zRlVURj0yRDg9M0ZCPTNGUTJGbXc2a2dZM0xEcUcxbD0zRj0zRD89?=",
+			"Café crème"},
+		// Decoded text that is not an encoded-word is left alone
+		{"=?UTF-8?Q?=3D=3Fnot_a_word?=", "=?not a word"},
 	}
 
 	for _, tt := range testTable {
+		got := decodeHeader(tt.in)
+		if got != tt.want {
+			t.Errorf("decodeHeader(%q) == %q, want: %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// Test re-encoding to base64
+// utf8Base64HeaderTests are shared by the tests of decodeToUTF8Base64Header and
+// EncodeToUTF8Base64
+var utf8Base64HeaderTests = []struct {
+	in, want string
+}{
+	{"no encoding", "no encoding"},
+	{"=?utf-8?q?abcABC_=24_=c2=a2_=e2=82=ac?=", "=?UTF-8?b?YWJjQUJDICQgwqIg4oKs?="},
+	{"=?iso-8859-1?q?#=a3_c=a9_r=ae_u=b5?=", "=?UTF-8?b?I8KjIGPCqSBywq4gdcK1?="},
+	{"=?big5?q?=a1=5d_=a1=61_=a1=71?=", "=?UTF-8?b?77yIIO+9myDjgIg=?="},
+	// Must respect separate tokens
+	{"=?UTF-8?Q?Miros=C5=82aw?= <u@h>", "=?UTF-8?b?TWlyb3PFgmF3?= <u@h>"},
+	{"First Last <u@h> (=?iso-8859-1?q?#=a3_c=a9_r=ae_u=b5?=)",
+		"First Last <u@h> (=?UTF-8?b?I8KjIGPCqSBywq4gdcK1?=)"},
+	// Lowercase encoding letters, mixed-case charset names
+	{"=?utf-8?b?TWlyb3PFgmF3?=", "=?UTF-8?b?TWlyb3PFgmF3?="},
+	{"=?uTf-8?B?TWlyb3PFgmF3?=", "=?UTF-8?b?TWlyb3PFgmF3?="},
+	{"=?Iso-8859-1?Q?caf=E9?=", "=?UTF-8?b?Y2Fmw6k=?="},
+	{"=?ISO-8859-2?q?=B1?=", "=?UTF-8?b?xIU=?="},
+	{"=?Windows-1252?b?gA==?=", "=?UTF-8?b?4oKs?="},
+	// Literal text adjacent to encoded-words within a single token
+	{"=?UTF-8?Q?Jos=C3=A9?=Smith <u@h>", "=?UTF-8?b?Sm9zw6k=?=Smith <u@h>"},
+	{"Mr.=?UTF-8?Q?Jos=C3=A9?=", "Mr.=?UTF-8?b?Sm9zw6k=?="},
+	{"[=?UTF-8?Q?=C3=A9?=]=?UTF-8?Q?a?=.", "[=?UTF-8?b?w6k=?=]a."},
+	{"literal=?text", "literal=?text"},
+}
+
+func TestDecodeToUTF8Base64Header(t *testing.T) {
+	for _, tt := range utf8Base64HeaderTests {
 		got := decodeToUTF8Base64Header(tt.in)
 		if got != tt.want {
 			t.Errorf("DecodeHeader(%q) == %q, want: %q", tt.in, got, tt.want)
@@ -158,6 +256,94 @@ func TestDecodeToUTF8Base64Header(t *testing.T) {
 	}
 }
 
+func TestEncodeToUTF8Base64(t *testing.T) {
+	for _, tt := range utf8Base64HeaderTests {
+		got := EncodeToUTF8Base64(tt.in)
+		if got != tt.want {
+			t.Errorf("EncodeToUTF8Base64(%q) == %q, want: %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFixSingleQuotedParams(t *testing.T) {
+	var ttable = []struct {
+		input, want string
+		fixed       bool
+	}{
+		{"text/plain", "text/plain", false},
+		{`text/plain; charset="utf-8"`, `text/plain; charset="utf-8"`, false},
+		{"text/plain; charset='utf-8'", `text/plain; charset="utf-8"`, true},
+		{"text/plain;charset = 'utf-8' ", `text/plain;charset = "utf-8" `, true},
+		{"text/plain; charset='utf-8'; format='flowed'; delsp=yes",
+			`text/plain; charset="utf-8"; format="flowed"; delsp=yes`, true},
+		{"text/plain; name='a'; charset='b'; format='c'",
+			`text/plain; name="a"; charset="b"; format="c"`, true},
+		// RFC 2231 extended parameters use single quotes as separators
+		{"attachment; filename*=us-ascii'en-us'This%20is.txt",
+			"attachment; filename*=us-ascii'en-us'This%20is.txt", false},
+		{"attachment; filename*=''foo'", "attachment; filename*=''foo'", false},
+		{"attachment; filename*0*='en'foo; filename*1='bar'",
+			"attachment; filename*0*='en'foo; filename*1='bar'", false},
+	}
+
+	for _, tt := range ttable {
+		got, fixed := fixSingleQuotedParams(tt.input)
+		if got != tt.want {
+			t.Errorf("fixSingleQuotedParams(%q) == %q, want: %q", tt.input, got, tt.want)
+		}
+		if fixed != tt.fixed {
+			t.Errorf("fixSingleQuotedParams(%q) fixed == %v, want: %v", tt.input, fixed, tt.fixed)
+		}
+	}
+}
+
+func TestDecodeToUTF8Base64HeaderLongWord(t *testing.T) {
+	subject := strings.Repeat("Réunion trimestrielle — ", 6)
+	// A single encoded-word far exceeding the RFC 2047 limit of 75 characters
+	input := "=?UTF-8?b?" + base64.StdEncoding.EncodeToString([]byte(subject)) + "?="
+	if len(input) <= 75 {
+		t.Fatalf("Test input is only %v characters long", len(input))
+	}
+
+	got := decodeToUTF8Base64Header(input)
+	words := strings.Fields(got)
+	if len(words) < 2 {
+		t.Errorf("decodeToUTF8Base64Header(%q) == %q, want multiple encoded-words", input, got)
+	}
+	for _, w := range words {
+		if len(w) > 75 {
+			t.Errorf("Encoded-word %q is %v characters long, want <= 75", w, len(w))
+		}
+		if !encodedWordRegexp.MatchString(w) || encodedWordRegexp.FindString(w) != w {
+			t.Errorf("%q is not a valid encoded-word", w)
+		}
+	}
+	if decoded := decodeHeader(got); decoded != subject {
+		t.Errorf("Decoded result got: %q, want: %q", decoded, subject)
+	}
+}
+
+func TestQuoteParamValues(t *testing.T) {
+	var ttable = []struct {
+		input, want string
+	}{
+		{"text/plain", "text/plain"},
+		{"multipart/mixed; boundary=----=_Part_0", `multipart/mixed; boundary="----=_Part_0"`},
+		{`multipart/mixed; boundary="----=_Part_0"`, `multipart/mixed; boundary="----=_Part_0"`},
+		{"text/plain; charset=utf-8 ; format=a=b", `text/plain; charset="utf-8" ; format="a=b"`},
+		{`attachment; filename=C:\a.txt`, `attachment; filename="C:\\a.txt"`},
+		{"attachment; filename*=utf-8''a%20b", "attachment; filename*=utf-8''a%20b"},
+		{`attachment; name="x; y=z"; a=b=c`, `attachment; name="x; y=z"; a="b=c"`},
+		{`attachment; name="x\"; y=z"; a=b`, `attachment; name="x\"; y=z"; a="b"`},
+	}
+
+	for _, tt := range ttable {
+		if got := quoteParamValues(tt.input); got != tt.want {
+			t.Errorf("quoteParamValues(%q) == %q, want: %q", tt.input, got, tt.want)
+		}
+	}
+}
+
 func TestReadHeader(t *testing.T) {
 	prefix := "From: hooman\n \n being\n"
 	suffix := "Subject: hi\n\nPart body\n"
@@ -274,7 +460,7 @@ func TestReadHeader(t *testing.T) {
 		r := bufio.NewReader(strings.NewReader(prefix + tt.input + suffix))
 
 		p := &Part{}
-		header, err := readHeader(r, p)
+		header, err := readHeader(r, p, &Parser{})
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -306,6 +492,12 @@ func TestReadHeader(t *testing.T) {
 		if gotErrs != wantErrs {
 			t.Errorf("Got %v p.Errors, want %v\ninput: %q", gotErrs, wantErrs, tt.input)
 		}
+		if tt.hname == "X-Bad-Continuation" && gotErrs == 1 {
+			// The line is merged, but the repair is reported
+			if perr := p.Errors[0]; perr.Name != ErrorNonStandardContinuation || perr.Severe {
+				t.Errorf("Got error %v, want a %q warning", perr.String(), ErrorNonStandardContinuation)
+			}
+		}
 
 		// readHeader should have consumed the two header lines, and the blank line, but not the
 		// body
@@ -323,3 +515,148 @@ func TestReadHeader(t *testing.T) {
 		}
 	}
 }
+
+// foldedReferences builds a References header folded across n continuation lines
+func foldedReferences(n int) string {
+	buf := &bytes.Buffer{}
+	buf.WriteString("References: <0@example.com>\r\n")
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(buf, " <%d@example.com>\r\n", i)
+	}
+	return buf.String()
+}
+
+func TestReadHeaderManyContinuations(t *testing.T) {
+	input := foldedReferences(10000) + "Subject: hi\r\n\r\nPart body\r\n"
+
+	// Unlimited
+	p := &Part{}
+	header, err := readHeader(bufio.NewReader(strings.NewReader(input)), p, &Parser{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	refs := strings.Fields(header.Get("References"))
+	if len(refs) != 10001 {
+		t.Errorf("Got %v references, want 10001", len(refs))
+	}
+	if got := header.Get("Subject"); got != "hi" {
+		t.Errorf("Subject header got: %q, want: %q", got, "hi")
+	}
+	if len(p.Errors) != 0 {
+		t.Errorf("Got %v p.Errors, want 0", len(p.Errors))
+	}
+
+	// Limited
+	p = &Part{}
+	opts := &Parser{MaxHeaderContinuations: 100}
+	header, err = readHeader(bufio.NewReader(strings.NewReader(input)), p, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refs = strings.Fields(header.Get("References"))
+	if len(refs) != 101 {
+		t.Errorf("Got %v references, want 101", len(refs))
+	}
+	if got := header.Get("Subject"); got != "hi" {
+		t.Errorf("Subject header got: %q, want: %q", got, "hi")
+	}
+	if len(p.Errors) != 1 {
+		t.Fatalf("Got %v p.Errors, want 1", len(p.Errors))
+	}
+	if p.Errors[0].Name != string(errorMalformedHeader) || p.Errors[0].Severe {
+		t.Errorf("Got error %v, want a %q warning", p.Errors[0].String(), errorMalformedHeader)
+	}
+}
+
+func TestReadHeaderTooLong(t *testing.T) {
+	input := "Subject: hi\r\n" +
+		"X-Test: " + strings.Repeat("x", 1000) + "\r\n" +
+		"X-Folded: " + strings.Repeat("y", 500) + "\r\n " + strings.Repeat("z", 500) + "\r\n" +
+		"\r\n"
+
+	p := &Part{}
+	opts := &Parser{MaxHeaderLength: 998}
+	header, err := readHeader(bufio.NewReader(strings.NewReader(input)), p, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(header.Get("X-Test")); got != 1000 {
+		t.Errorf("X-Test value length got: %v, want: 1000", got)
+	}
+	// The folded value is longer than the limit, but none of its lines are
+	if len(p.Errors) != 1 {
+		t.Fatalf("Got %v p.Errors, want 1: %v", len(p.Errors), p.Errors)
+	}
+	perr := p.Errors[0]
+	if perr.Name != ErrorHeaderTooLong || perr.Severe {
+		t.Errorf("Got error %v, want a %q warning", perr.String(), ErrorHeaderTooLong)
+	}
+	if !strings.Contains(perr.Detail, "X-Test") {
+		t.Errorf("Error detail %q should name header %q", perr.Detail, "X-Test")
+	}
+
+	// Configurable limit
+	for _, max := range []int{1008, 0} {
+		p = &Part{}
+		if _, err := readHeader(bufio.NewReader(strings.NewReader(input)), p,
+			&Parser{MaxHeaderLength: max}); err != nil {
+			t.Fatal(err)
+		}
+		if len(p.Errors) != 0 {
+			t.Errorf("MaxHeaderLength %v got %v p.Errors, want 0: %v", max, len(p.Errors), p.Errors)
+		}
+	}
+}
+
+func TestReadHeaderMissingColon(t *testing.T) {
+	testCases := []struct {
+		input   string
+		from    string
+		subject string
+		errName string
+	}{
+		{
+			// Between two valid headers, joined to the previous header
+			"From: alice@example.com\r\nstray line\r\nSubject: hi\r\n\r\n",
+			"alice@example.com stray line",
+			"hi",
+			ErrorNonStandardContinuation,
+		},
+		{
+			// Before any header, discarded
+			"stray line\r\nFrom: alice@example.com\r\nSubject: hi\r\n\r\n",
+			"alice@example.com",
+			"hi",
+			ErrorMalformedHeader,
+		},
+	}
+	for _, tc := range testCases {
+		p := &Part{}
+		header, err := readHeader(bufio.NewReader(strings.NewReader(tc.input)), p, &Parser{})
+		if err != nil {
+			t.Fatalf("Unexpected error for %q: %v", tc.input, err)
+		}
+		if got := header.Get("From"); got != tc.from {
+			t.Errorf("From header got: %q, want: %q", got, tc.from)
+		}
+		if got := header.Get("Subject"); got != tc.subject {
+			t.Errorf("Subject header got: %q, want: %q", got, tc.subject)
+		}
+		if len(p.Errors) != 1 {
+			t.Fatalf("Got %v p.Errors, want 1", len(p.Errors))
+		}
+		if p.Errors[0].Name != tc.errName || p.Errors[0].Severe {
+			t.Errorf("Got error %v, want a %q warning", p.Errors[0].String(), tc.errName)
+		}
+	}
+}
+
+func BenchmarkReadHeaderContinuations(b *testing.B) {
+	input := foldedReferences(10000) + "\r\n"
+	for i := 0; i < b.N; i++ {
+		_, err := readHeader(bufio.NewReader(strings.NewReader(input)), &Part{}, &Parser{})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package enmime
+
+import (
+	"encoding/base64"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// htmlResourceRegexp matches src, href and background attributes in HTML, capturing the attribute
+// value in either the double or single quoted group
+var htmlResourceRegexp = regexp.MustCompile(
+	`(?i)(\b(?:src|href|background)\s*=\s*)(?:"([^"]*)"|'([^']*)')`)
+
+// InlineHTML returns the HTML portion of the message with references to parts of the message
+// replaced by data URIs, making it self-contained for display.  References may use a "cid:" URL
+// matching a Content-ID (see InlineByContentID), or a URL matching a part's Content-Location
+// header, as used by Outlook.  Relative Content-Location references are also matched by their
+// final path element, ie "image001.png" matches "file:///C:/temp/image001.png".  References that
+// cannot be resolved, or whose content cannot be read, are left unchanged.
+func (e *Envelope) InlineHTML() string {
+	if e.HTML == "" || e.Root == nil {
+		return e.HTML
+	}
+	return htmlResourceRegexp.ReplaceAllStringFunc(e.HTML, func(attr string) string {
+		m := htmlResourceRegexp.FindStringSubmatch(attr)
+		quote, ref := `"`, m[2]
+		if strings.HasPrefix(attr[len(m[1]):], "'") {
+			quote, ref = "'", m[3]
+		}
+		p := e.partByReference(ref)
+		if p == nil {
+			return attr
+		}
+		uri, err := dataURI(p)
+		if err != nil {
+			return attr
+		}
+		return m[1] + quote + uri + quote
+	})
+}
+
+// partByReference returns the Part referenced by a URL in HTML, or nil if there is none.
+func (e *Envelope) partByReference(ref string) *Part {
+	ref = strings.TrimSpace(ref)
+	if ref == "" || strings.HasPrefix(ref, "#") {
+		return nil
+	}
+	if len(ref) >= 4 && strings.EqualFold(ref[:4], "cid:") {
+		return e.InlineByContentID(ref)
+	}
+	return e.Root.DepthMatchFirst(func(p *Part) bool {
+		loc := strings.TrimSpace(p.Header.Get(hnContentLocation))
+		if loc == "" {
+			return false
+		}
+		if loc == ref {
+			return true
+		}
+		// Relative reference against an absolute location
+		return !strings.Contains(ref, "/") && !strings.Contains(ref, ":") &&
+			path.Base(strings.Replace(loc, "\\", "/", -1)) == ref
+	})
+}
+
+// dataURI returns an RFC 2397 data URI for the content of p.
+func dataURI(p *Part) (string, error) {
+	ctype := p.ContentType
+	if ctype == "" {
+		ctype = ctAppOctetStream
+	}
+	content, err := p.readContent()
+	if err != nil {
+		return "", err
+	}
+	return "data:" + ctype + ";base64," + base64.StdEncoding.EncodeToString(content), nil
+}
@@ -0,0 +1,65 @@
+package enmime
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEnvelopeInlineHTML(t *testing.T) {
+	raw := "From: user@inbucket.org\r\n" +
+		"Content-Type: multipart/related; boundary=Enmime-100\r\n" +
+		"\r\n" +
+		"--Enmime-100\r\n" +
+		"Content-Type: text/html\r\n" +
+		"\r\n" +
+		"<img src=\"cid:logo@x\"><img src='image001.png'>" +
+		"<img src=\"http://example.com/image001.png\"><a href=\"#top\">top</a>\r\n" +
+		"--Enmime-100\r\n" +
+		"Content-Type: image/png\r\n" +
+		"Content-Disposition: inline; filename=logo.png\r\n" +
+		"Content-ID: <logo@x>\r\n" +
+		"\r\n" +
+		"LOGO\r\n" +
+		"--Enmime-100\r\n" +
+		"Content-Type: image/png\r\n" +
+		"Content-Location: file:///C:/Users/x/AppData/image001.png\r\n" +
+		"\r\n" +
+		"IMG1\r\n" +
+		"--Enmime-100--\r\n"
+	e, err := ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+
+	want := "<img src=\"data:image/png;base64,TE9HTw==\">" +
+		"<img src='data:image/png;base64,SU1HMQ=='>" +
+		"<img src=\"http://example.com/image001.png\"><a href=\"#top\">top</a>"
+	if got := e.InlineHTML(); got != want {
+		t.Errorf("InlineHTML()\ngot : %q\nwant: %q", got, want)
+	}
+}
+
+func TestEnvelopeInlineHTMLContentLocation(t *testing.T) {
+	raw := "From: user@inbucket.org\r\n" +
+		"Content-Type: multipart/related; boundary=Enmime-100\r\n" +
+		"\r\n" +
+		"--Enmime-100\r\n" +
+		"Content-Type: text/html\r\n" +
+		"\r\n" +
+		"<img src=\"http://example.com/a.gif\">\r\n" +
+		"--Enmime-100\r\n" +
+		"Content-Type: image/gif\r\n" +
+		"Content-Location: http://example.com/a.gif\r\n" +
+		"\r\n" +
+		"GIF\r\n" +
+		"--Enmime-100--\r\n"
+	e, err := ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+
+	want := "<img src=\"data:image/gif;base64,R0lG\">"
+	if got := e.InlineHTML(); got != want {
+		t.Errorf("InlineHTML()\ngot : %q\nwant: %q", got, want)
+	}
+}
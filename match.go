@@ -105,3 +105,17 @@ func (p *Part) DepthMatchAll(matcher PartMatcher) []*Part {
 		}
 	}
 }
+
+// Walk calls fn for p and each of its descendants in depth first order, the same order used by
+// the DepthMatch* functions.  If fn returns an error the walk stops and that error is returned.
+func (p *Part) Walk(fn func(*Part) error) error {
+	if err := fn(p); err != nil {
+		return err
+	}
+	for c := p.FirstChild; c != nil; c = c.NextSibling {
+		if err := c.Walk(fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
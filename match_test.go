@@ -1,6 +1,8 @@
 package enmime
 
 import (
+	"errors"
+	"strings"
 	"testing"
 )
 
@@ -180,3 +182,55 @@ func TestDepthMatchAll(t *testing.T) {
 		t.Error("DepthMatchAll should have returned a3, got:", ps[1].FileName)
 	}
 }
+
+func TestPartWalk(t *testing.T) {
+	// Setup test MIME tree:
+	//    root
+	//    ├── a1
+	//    │   ├── b1
+	//    │   └── b2
+	//    ├── a2
+	//    └── a3
+
+	root := &Part{ContentType: "multipart/alternative", FileName: "root"}
+	a1 := &Part{ContentType: "multipart/related", Parent: root, FileName: "a1"}
+	a2 := &Part{ContentType: "text/plain", Parent: root, FileName: "a2"}
+	a3 := &Part{ContentType: "text/html", Parent: root, FileName: "a3"}
+	b1 := &Part{ContentType: "text/plain", Parent: a1, FileName: "b1"}
+	b2 := &Part{ContentType: "text/html", Parent: a1, FileName: "b2"}
+	root.FirstChild = a1
+	a1.NextSibling = a2
+	a2.NextSibling = a3
+	a1.FirstChild = b1
+	b1.NextSibling = b2
+
+	var got []string
+	err := root.Walk(func(p *Part) error {
+		got = append(got, p.FileName)
+		return nil
+	})
+	if err != nil {
+		t.Fatal("Walk returned error:", err)
+	}
+	want := "root a1 b1 b2 a2 a3"
+	if strings.Join(got, " ") != want {
+		t.Errorf("Walk visited: %q, want: %q", strings.Join(got, " "), want)
+	}
+
+	// An error stops the walk
+	stop := errors.New("stop")
+	got = nil
+	err = root.Walk(func(p *Part) error {
+		got = append(got, p.FileName)
+		if p == b1 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Errorf("Walk returned error: %v, want: %v", err, stop)
+	}
+	if strings.Join(got, " ") != "root a1 b1" {
+		t.Errorf("Walk visited: %q, want: %q", strings.Join(got, " "), "root a1 b1")
+	}
+}
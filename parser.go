@@ -0,0 +1,262 @@
+package enmime
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/mail"
+	"sort"
+	"time"
+)
+
+// Parser holds options that control how messages are parsed.  The zero value is ready to use and
+// parses messages the same way as the package level ReadEnvelope function.  State used during a
+// parse is kept on a per-parse copy of the Parser, so a Parser may be used by multiple goroutines
+// simultaneously provided its fields are not modified.  The package level registries,
+// SetCharsetReader, SetFileNameSanitizer, RegisterMediaTypeFixer, RegisterTransferDecoder and the
+// AddressHeaders map, are shared by all parses; they should be configured before parsing begins.
+type Parser struct {
+	// Strict causes parsing to fail when a condition is encountered that would otherwise produce a
+	// non-severe Error (a warning), rather than making a best-effort repair.  Warnings that do not
+	// indicate malformed input, such as ErrorPlainTextFromHTML, are still returned in
+	// Envelope.Errors.
+	Strict bool
+
+	// MaxHeaderContinuations limits the number of continuation lines a single header may be folded
+	// across; additional lines are discarded with a warning.  Zero means no limit.
+	MaxHeaderContinuations int
+
+	// MaxHeaderLength is the length in bytes a header line may reach, excluding the CRLF, before a
+	// warning is recorded; parsing continues regardless.  Long values folded across several lines
+	// are accepted.  RFC 5322 limits lines to 998 bytes.  Zero means no limit.
+	MaxHeaderLength int
+
+	// ChainTransferEncodings causes a nonstandard Content-Transfer-Encoding listing several
+	// encodings, such as "quoted-printable, base64", to be decoded with each in turn, starting with
+	// the last.  By default only the last encoding listed is decoded.
+	ChainTransferEncodings bool
+
+	// RawSignedContent causes each child of a multipart/signed part to retain its exact,
+	// undecoded bytes, including headers and line endings, in Part.RawContent.  These are the
+	// bytes a signature must be verified against.
+	RawSignedContent bool
+
+	// MaxParts limits the total number of parts in a message, including the root.  When exceeded,
+	// parsing stops and a severe Error is recorded; the parts read so far are still returned.  Zero
+	// means no limit.
+	MaxParts int
+
+	// MaxPartBytes limits the size of the raw content of any single part.  When exceeded, parsing
+	// stops and a severe Error is recorded; the parts read so far are still returned.  Zero means
+	// no limit.
+	MaxPartBytes int64
+
+	// NestingWarningDepth causes a warning to be recorded on the first part found nested more than
+	// this many levels below the root.  Parsing continues normally; deep nesting is rarely
+	// legitimate, so the warning is useful for flagging suspicious messages.  Zero disables the
+	// warning.
+	NestingWarningDepth int
+
+	// RawHeaderCharset is the character set used to decode header values containing raw 8-bit
+	// bytes that are not valid UTF-8, as sent by mailers that do not support RFC 2047 or RFC 6532.
+	// It applies to file names and descriptions while parsing, and to the header accessors of the
+	// resulting Envelope.  Empty leaves such values untouched.
+	RawHeaderCharset string
+
+	// DecodeQPSubject enables a heuristic that detects a Subject header containing raw
+	// quoted-printable, without RFC 2047 encoded-word syntax, and decodes it.  RawHeaderCharset is
+	// used to interpret the decoded bytes.
+	DecodeQPSubject bool
+
+	// StripAddressRoutes removes obsolete source routes, such as "<@a,@b:user@host>", from address
+	// headers with a warning, so that Envelope.AddressList is able to parse them.  By default
+	// address headers are left as-is, and are parsed strictly by net/mail.
+	StripAddressRoutes bool
+
+	// NormalizeLineEndings converts CRLF and CR line endings in the decoded content of text parts
+	// to LF.  Binary parts are left untouched.
+	NormalizeLineEndings bool
+
+	// RepairMojibake applies the RepairMojibake function to the Text and HTML of parsed Envelopes.
+	RepairMojibake bool
+
+	// SniffTransferEncoding enables a heuristic for parts without a Content-Transfer-Encoding
+	// header: content that looks like base64 or quoted-printable is decoded as such, with a warning.
+	SniffTransferEncoding bool
+
+	// HeadersOnly causes the content of each part to be read and discarded rather than decoded,
+	// leaving Part.Content nil.  The headers and structure of the message are still parsed, and
+	// the size of each part is available from its offsets.  It is useful when only the headers
+	// are of interest, such as when filtering or routing messages.
+	HeadersOnly bool
+
+	// TextPartSeparator is placed between the text/plain parts of a message when more than one
+	// forms the body, such as the inline parts of a multipart/mixed, as they are concatenated into
+	// Envelope.Text.  Empty uses the default separator "\n\n", a blank line.
+	TextPartSeparator string
+
+	// InMemoryThreshold causes the decoded content of non-text parts larger than this many bytes
+	// to be written to a temporary file rather than held in memory.  Part.Content of such a part is
+	// nil, its content is available by reading the Part, and the file is recorded in
+	// Part.TempPath.  The caller must remove the files with Envelope.Cleanup.  Zero means all
+	// content is held in memory.
+	InMemoryThreshold int64
+
+	// MediumSizeThreshold and LargeSizeThreshold are the Envelope.TotalSize in bytes at which
+	// Envelope.SizeCategory considers a message SizeMedium and SizeLarge respectively.  Zero uses
+	// DefaultMediumSizeThreshold and DefaultLargeSizeThreshold.
+	MediumSizeThreshold int
+	LargeSizeThreshold  int
+
+	// PartFunc, if set, is called as each Part is completed during parsing; children are completed
+	// before their parent, the root Part last.  It allows callers to process large parts as they
+	// are parsed, for example to stream attachments to storage and then release Part.Content.  If
+	// PartFunc returns an error, parsing is aborted and the error returned unchanged by ReadParts and
+	// ReadEnvelope.
+	PartFunc func(*Part) error
+
+	parts         int      // Number of parts read, tracked on a per-parse copy of the Parser
+	nestingWarned bool     // An excessive nesting warning has been recorded
+	tempPaths     []string // Temporary files created for InMemoryThreshold
+	embeddedDepth int      // Number of enclosing messages, when parsing an embedded message
+}
+
+// informationalErrors is the set of warnings that describe how a well-formed message was handled,
+// rather than a problem with it, so do not cause a parse to fail in strict mode.
+var informationalErrors = map[string]bool{
+	ErrorPlainTextFromHTML: true,
+}
+
+// ReadEnvelope parses the content of the provided reader into an Envelope using the options set on
+// the Parser.  See the package level ReadEnvelope function for details.
+//
+// In strict mode the first warning encountered is returned as the error, with a nil Envelope.
+func (p *Parser) ReadEnvelope(r io.Reader) (e *Envelope, err error) {
+	opts := p.parseState()
+	defer func() {
+		if r := recover(); r != nil {
+			e, err = nil, panicError(r)
+		}
+		if err != nil {
+			// The Envelope is not returned, remove any content written to temporary files
+			opts.removeTempFiles()
+		}
+	}()
+
+	// Read MIME parts from reader
+	start := time.Now()
+	root, err := readParts(r, opts)
+	if err != nil {
+		if perr, ok := err.(*partFuncError); ok {
+			return nil, perr.err
+		}
+		if _, ok := err.(*Error); ok || err == ErrEmptyMessage {
+			return nil, err
+		}
+		return nil, fmt.Errorf("Failed to ReadParts: %v", err)
+	}
+	e, err = envelopeFromPart(root, opts)
+	if err != nil {
+		return nil, err
+	}
+	if p.RepairMojibake {
+		e.Text = RepairMojibake(e.Text)
+		e.HTML = RepairMojibake(e.HTML)
+	}
+	e.Stats.Duration = time.Since(start)
+	if p.Strict {
+		for _, perr := range e.Errors {
+			if !perr.Severe && !informationalErrors[perr.Name] {
+				return nil, perr
+			}
+		}
+	}
+	return e, nil
+}
+
+// ReadParts reads a MIME document from the provided reader and parses it into tree of Part objects
+// using the options set on the Parser.
+func (p *Parser) ReadParts(r io.Reader) (root *Part, err error) {
+	opts := p.parseState()
+	defer func() {
+		if r := recover(); r != nil {
+			root, err = nil, panicError(r)
+		}
+		if err != nil {
+			// The parts are not returned, remove any content written to temporary files
+			opts.removeTempFiles()
+		}
+	}()
+
+	root, err = readParts(r, opts)
+	if perr, ok := err.(*partFuncError); ok {
+		err = perr.err
+	}
+	return root, err
+}
+
+// parseState returns a copy of the Parser to hold the state of a single parse, so that the state
+// is not shared between parses.
+func (p *Parser) parseState() *Parser {
+	o := *p
+	o.parts = 0
+	o.nestingWarned = false
+	o.tempPaths = nil
+	return &o
+}
+
+// partFuncError carries an error returned by Parser.PartFunc out of the parser, so that it may be
+// returned to the caller unchanged.
+type partFuncError struct {
+	err error
+}
+
+// Error implements the error interface.
+func (e *partFuncError) Error() string {
+	return e.err.Error()
+}
+
+// panicError converts a value recovered from a panic during parsing into a severe Error, so that
+// malformed input can never crash the caller.
+func panicError(r interface{}) error {
+	return &Error{
+		Name:   ErrorInternal,
+		Detail: fmt.Sprintf("Recovered from panic while parsing: %v", r),
+		Severe: true,
+		Code:   ErrCodeInternal,
+	}
+}
+
+// ReadEnvelopeFunc parses the content of the provided reader, calling fn as each Part is completed
+// rather than building an Envelope.  See Parser.PartFunc for details.  If fn returns an error,
+// parsing is aborted and the error returned.
+func ReadEnvelopeFunc(r io.Reader, fn func(*Part) error) error {
+	_, err := (&Parser{PartFunc: fn}).ReadParts(r)
+	return err
+}
+
+// ReadEnvelopeHeadersOnly parses the headers and MIME structure of the provided reader into an
+// Envelope without decoding the content of any part.  See Parser.HeadersOnly for details.
+func ReadEnvelopeHeadersOnly(r io.Reader) (*Envelope, error) {
+	return (&Parser{HeadersOnly: true}).ReadEnvelope(r)
+}
+
+// ReadEnvelopeFromMessage parses a message that has already been read with net/mail into an
+// Envelope.  The header of the message is taken from m.Header, and m.Body, which must be
+// positioned after the header, is parsed for the MIME structure.  Header fields are parsed in
+// sorted order, as the original order is not retained by net/mail.
+func ReadEnvelopeFromMessage(m *mail.Message) (*Envelope, error) {
+	keys := make([]string, 0, len(m.Header))
+	for k := range m.Header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	header := new(bytes.Buffer)
+	for _, k := range keys {
+		for _, v := range m.Header[k] {
+			fmt.Fprintf(header, "%s: %s\r\n", k, v)
+		}
+	}
+	header.WriteString("\r\n")
+	return ReadEnvelope(io.MultiReader(header, m.Body))
+}
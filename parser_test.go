@@ -0,0 +1,535 @@
+package enmime
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/mail"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParserStrict(t *testing.T) {
+	// Lenient mode returns warnings
+	msg := openTestData("low-quality", "bad-final-boundary.raw")
+	p := &Parser{}
+	e, err := p.ReadEnvelope(msg)
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+	if len(e.Errors) == 0 {
+		t.Fatal("Got 0 warnings, expected at least one")
+	}
+
+	// Strict mode fails on the first warning
+	msg = openTestData("low-quality", "bad-final-boundary.raw")
+	p = &Parser{Strict: true}
+	e, err = p.ReadEnvelope(msg)
+	if err == nil {
+		t.Fatal("Expected strict parse to fail, err was nil")
+	}
+	if e != nil {
+		t.Errorf("Expected nil Envelope, got: %+v", e)
+	}
+	perr, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("Expected err to be *Error, got: %T", err)
+	}
+	if perr.Name != string(errorMissingBoundary) {
+		t.Errorf("err.Name == %q, want: %q", perr.Name, errorMissingBoundary)
+	}
+}
+
+func TestParserStrictValidMessage(t *testing.T) {
+	msg := openTestData("mail", "mime-alternative.raw")
+	p := &Parser{Strict: true}
+	e, err := p.ReadEnvelope(msg)
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+	if e.Text == "" {
+		t.Error("Expected Text body to be populated")
+	}
+
+	// Converting an HTML only body to text is not a problem with the message
+	msg = openTestData("mail", "html-only-inline.raw")
+	e, err = p.ReadEnvelope(msg)
+	if err != nil {
+		t.Fatal("Failed to parse HTML only message:", err)
+	}
+	if e.Text == "" || len(e.Errors) != 1 || e.Errors[0].Name != ErrorPlainTextFromHTML {
+		t.Errorf("Got Text %.20q, errors %v, want text and a %q warning", e.Text, e.Errors,
+			ErrorPlainTextFromHTML)
+	}
+}
+
+func TestParserMaxParts(t *testing.T) {
+	raw := "Content-Type: multipart/mixed; boundary=Enmime\r\n\r\n"
+	for i := 0; i < 10; i++ {
+		raw += fmt.Sprintf("--Enmime\r\nContent-Type: text/plain\r\n\r\nPart %v\r\n", i)
+	}
+	raw += "--Enmime--\r\n"
+
+	p := &Parser{MaxParts: 4}
+	root, err := p.ReadParts(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Unexpected parse error:", err)
+	}
+
+	// Root plus three children
+	children := 0
+	for c := root.FirstChild; c != nil; c = c.NextSibling {
+		children++
+	}
+	if children != 3 {
+		t.Errorf("Got %v child parts, want 3", children)
+	}
+	if len(root.Errors) != 1 {
+		t.Fatalf("Got %v root.Errors, want 1", len(root.Errors))
+	}
+	if root.Errors[0].Name != ErrorLimitExceeded || !root.Errors[0].Severe {
+		t.Errorf("Got error %v, want severe %q", root.Errors[0].String(), ErrorLimitExceeded)
+	}
+
+	// Limit is tracked per parse
+	if _, err := p.ReadParts(strings.NewReader(raw)); err != nil {
+		t.Fatal("Unexpected parse error:", err)
+	}
+	if p.parts != 0 {
+		t.Errorf("Parser part count was modified: %v", p.parts)
+	}
+}
+
+// nestedMessage returns a message with multipart/mixed parts nested depth levels below the root,
+// the innermost containing a single text part.
+func nestedMessage(depth int) string {
+	raw := ""
+	for i := 0; i < depth; i++ {
+		raw += fmt.Sprintf("Content-Type: multipart/mixed; boundary=Nest%v\r\n\r\n--Nest%v\r\n", i, i)
+	}
+	raw += "Content-Type: text/plain\r\n\r\nDeep\r\n"
+	for i := depth - 1; i >= 0; i-- {
+		raw += fmt.Sprintf("--Nest%v--\r\n", i)
+	}
+	return raw
+}
+
+func TestParserNestingWarningDepth(t *testing.T) {
+	raw := nestedMessage(8)
+
+	p := &Parser{NestingWarningDepth: 5}
+	e, err := p.ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Unexpected parse error:", err)
+	}
+	if e.Text != "Deep" {
+		t.Errorf("Text got: %q, want: %q", e.Text, "Deep")
+	}
+	if len(e.Errors) != 1 {
+		t.Fatalf("Got %v e.Errors, want 1: %v", len(e.Errors), e.Errors)
+	}
+	perr := e.Errors[0]
+	if perr.Name != ErrorExcessiveNesting || perr.Severe {
+		t.Errorf("Got error %v, want a %q warning", perr.String(), ErrorExcessiveNesting)
+	}
+	if !strings.Contains(perr.Detail, "6 levels") {
+		t.Errorf("Error detail %q should contain the depth", perr.Detail)
+	}
+
+	// Nesting within the threshold, or without one, is not reported
+	for _, p := range []*Parser{{NestingWarningDepth: 8}, {}} {
+		e, err := p.ReadEnvelope(strings.NewReader(raw))
+		if err != nil {
+			t.Fatal("Unexpected parse error:", err)
+		}
+		if len(e.Errors) != 0 {
+			t.Errorf("NestingWarningDepth %v got errors: %v", p.NestingWarningDepth, e.Errors)
+		}
+	}
+}
+
+func TestParserMaxPartBytes(t *testing.T) {
+	raw := "Content-Type: multipart/mixed; boundary=Enmime\r\n" +
+		"\r\n" +
+		"--Enmime\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"Small\r\n" +
+		"--Enmime\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		strings.Repeat("Large", 100) + "\r\n" +
+		"--Enmime\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"Unreached\r\n" +
+		"--Enmime--\r\n"
+
+	p := &Parser{MaxPartBytes: 100}
+	e, err := p.ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Unexpected parse error:", err)
+	}
+	if !strings.HasPrefix(e.Text, "Small") {
+		t.Errorf("Text == %q, want prefix: %q", e.Text, "Small")
+	}
+	if len(e.Errors) != 1 {
+		t.Fatalf("Got %v e.Errors, want 1", len(e.Errors))
+	}
+	if e.Errors[0].Name != ErrorLimitExceeded || !e.Errors[0].Severe {
+		t.Errorf("Got error %v, want severe %q", e.Errors[0].String(), ErrorLimitExceeded)
+	}
+	if strings.Contains(e.Text, "Large") || strings.Contains(e.Text, "Unreached") {
+		t.Error("Parsing should have stopped at the oversized part")
+	}
+}
+
+func TestParserDecodeQPSubject(t *testing.T) {
+	raw := "From: user@inbucket.org\r\n" +
+		"Subject: Caf=E9 cr=E8me\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"Body\r\n"
+
+	p := &Parser{RawHeaderCharset: "iso-8859-1"}
+	e, err := p.ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+	if got, want := e.GetHeader("Subject"), "Caf=E9 cr=E8me"; got != want {
+		t.Errorf("Subject without DecodeQPSubject got: %q, want: %q", got, want)
+	}
+
+	p.DecodeQPSubject = true
+	e, err = p.ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+	if got, want := e.GetHeader("Subject"), "Café crème"; got != want {
+		t.Errorf("Subject with DecodeQPSubject got: %q, want: %q", got, want)
+	}
+	if len(e.Errors) != 1 {
+		t.Errorf("Got %v e.Errors, want 1", len(e.Errors))
+	}
+
+	// Subjects that do not look like quoted-printable are left alone
+	for _, subject := range []string{
+		"Equation x=1E3 and y=2F4 in a long enough subject line",
+		"=?UTF-8?Q?Caf=C3=A9?= =E9",
+		"Price =A3",
+	} {
+		raw := "Subject: " + subject + "\r\n\r\nBody\r\n"
+		e, err = p.ReadEnvelope(strings.NewReader(raw))
+		if err != nil {
+			t.Fatal("Failed to parse MIME:", err)
+		}
+		if got := e.Root.Header.Get("Subject"); got != subject {
+			t.Errorf("Subject got: %q, want: %q", got, subject)
+		}
+	}
+}
+
+func TestParserRepairMojibake(t *testing.T) {
+	raw := "Content-Type: text/plain; charset=utf-8\r\n\r\nCafÃ© crÃ¨me"
+
+	e, err := ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+	if got, want := e.Text, "CafÃ© crÃ¨me"; got != want {
+		t.Errorf("Text without RepairMojibake got: %q, want: %q", got, want)
+	}
+
+	p := &Parser{RepairMojibake: true}
+	e, err = p.ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+	if got, want := e.Text, "Café crème"; got != want {
+		t.Errorf("Text with RepairMojibake got: %q, want: %q", got, want)
+	}
+}
+
+func TestReadEnvelopeFunc(t *testing.T) {
+	// Collect parts via the callback
+	var got []*Part
+	err := ReadEnvelopeFunc(openTestData("mail", "html-mime-inline.raw"), func(p *Part) error {
+		got = append(got, p)
+		return nil
+	})
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+
+	// Compare with a full parse
+	root, err := ReadParts(openTestData("mail", "html-mime-inline.raw"))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+	want := root.DepthMatchAll(func(p *Part) bool { return true })
+	if len(got) != len(want) {
+		t.Fatalf("Callback received %v parts, want %v", len(got), len(want))
+	}
+	describe := func(p *Part) string {
+		return p.ContentType + ":" + p.ContentID + ":" + string(p.Content)
+	}
+	for _, wp := range want {
+		found := false
+		for _, gp := range got {
+			if describe(gp) == describe(wp) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("Callback did not receive part %q", wp.ContentType)
+		}
+	}
+	if got[len(got)-1].Parent != nil {
+		t.Error("Root part should be completed last")
+	}
+}
+
+func TestReadEnvelopeFuncAbort(t *testing.T) {
+	errAbort := fmt.Errorf("abort")
+	calls := 0
+	err := ReadEnvelopeFunc(openTestData("mail", "html-mime-inline.raw"), func(p *Part) error {
+		calls++
+		return errAbort
+	})
+	if err != errAbort {
+		t.Errorf("ReadEnvelopeFunc() error == %v, want: %v", err, errAbort)
+	}
+	if calls != 1 {
+		t.Errorf("Callback was called %v times, want 1", calls)
+	}
+
+	// The error is returned unchanged by ReadEnvelope
+	p := &Parser{PartFunc: func(*Part) error { return errAbort }}
+	e, err := p.ReadEnvelope(openTestData("mail", "html-mime-inline.raw"))
+	if e != nil || err != errAbort {
+		t.Errorf("ReadEnvelope() got: %v, %v, want: nil, %v", e, err, errAbort)
+	}
+}
+
+func TestReadEnvelopeHeadersOnly(t *testing.T) {
+	e, err := ReadEnvelopeHeadersOnly(openTestData("mail", "html-mime-inline.raw"))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+	full, err := ReadEnvelope(openTestData("mail", "html-mime-inline.raw"))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+
+	if got, want := e.GetHeader("Subject"), full.GetHeader("Subject"); got != want {
+		t.Errorf("Subject == %q, want: %q", got, want)
+	}
+	if e.Text != "" || e.HTML != "" {
+		t.Errorf("Got Text %q and HTML %q, want empty", e.Text, e.HTML)
+	}
+
+	got := e.Root.DepthMatchAll(func(p *Part) bool { return true })
+	want := full.Root.DepthMatchAll(func(p *Part) bool { return true })
+	if len(got) != len(want) {
+		t.Fatalf("Got %v parts, want %v", len(got), len(want))
+	}
+	for i := range got {
+		if got[i].Content != nil {
+			t.Errorf("Part %v Content == %q, want nil", i, got[i].Content)
+		}
+		if got[i].ContentType != want[i].ContentType {
+			t.Errorf("Part %v ContentType == %q, want: %q", i, got[i].ContentType,
+				want[i].ContentType)
+		}
+		if got[i].Header.Get("Content-Type") != want[i].Header.Get("Content-Type") {
+			t.Errorf("Part %v Content-Type header == %q, want: %q", i,
+				got[i].Header.Get("Content-Type"), want[i].Header.Get("Content-Type"))
+		}
+		if got[i].StartOffset != want[i].StartOffset || got[i].EndOffset != want[i].EndOffset {
+			t.Errorf("Part %v offsets == %v-%v, want: %v-%v", i, got[i].StartOffset,
+				got[i].EndOffset, want[i].StartOffset, want[i].EndOffset)
+		}
+	}
+	if len(e.Inlines) != len(full.Inlines) {
+		t.Errorf("len(Inlines) == %v, want: %v", len(e.Inlines), len(full.Inlines))
+	}
+}
+
+func TestReadEnvelopeFromMessage(t *testing.T) {
+	m := &mail.Message{
+		Header: mail.Header{
+			"From":         {"James Hillyerd <james@inbucket.org>"},
+			"Subject":      {"=?UTF-8?Q?Caf=C3=A9?="},
+			"Content-Type": {`multipart/alternative; boundary="Enmime-Test"`},
+		},
+		Body: strings.NewReader("--Enmime-Test\r\n" +
+			"Content-Type: text/plain\r\n" +
+			"\r\n" +
+			"Plain\r\n" +
+			"--Enmime-Test\r\n" +
+			"Content-Type: text/html\r\n" +
+			"\r\n" +
+			"<b>HTML</b>\r\n" +
+			"--Enmime-Test--\r\n"),
+	}
+	e, err := ReadEnvelopeFromMessage(m)
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+	if got, want := e.GetHeader("Subject"), "Café"; got != want {
+		t.Errorf("Subject == %q, want: %q", got, want)
+	}
+	if got, want := e.GetHeader("From"), "James Hillyerd <james@inbucket.org>"; got != want {
+		t.Errorf("From == %q, want: %q", got, want)
+	}
+	if e.Text != "Plain" || e.HTML != "<b>HTML</b>" {
+		t.Errorf("Got Text %q and HTML %q, want %q and %q", e.Text, e.HTML, "Plain", "<b>HTML</b>")
+	}
+	if e.Root.ContentType != "multipart/alternative" || e.Root.FirstChild == nil {
+		t.Errorf("Root got: %q with no children, want multipart/alternative", e.Root.ContentType)
+	}
+
+	// A message read by net/mail parses the same as the raw input
+	m, err = mail.ReadMessage(openTestData("mail", "html-mime-inline.raw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err = ReadEnvelopeFromMessage(m)
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+	full, err := ReadEnvelope(openTestData("mail", "html-mime-inline.raw"))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+	if e.Text != full.Text || e.HTML != full.HTML || len(e.Inlines) != len(full.Inlines) {
+		t.Errorf("Got Text %q, HTML %q, %v inlines, want %q, %q, %v", e.Text, e.HTML,
+			len(e.Inlines), full.Text, full.HTML, len(full.Inlines))
+	}
+}
+
+func TestParserStripAddressRoutes(t *testing.T) {
+	raw := "From: Alice <@relay1.example.com,@relay2.example.com:alice@example.com>\r\n" +
+		"To: bob@example.com, Carol <@relay.example.com:carol@example.com>\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"Body\r\n"
+
+	// Default is strict
+	e, err := ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+	if _, err := e.AddressList("From"); err == nil {
+		t.Error("Expected AddressList to reject a source routed address")
+	}
+
+	p := &Parser{StripAddressRoutes: true}
+	e, err = p.ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+	from, err := e.AddressList("From")
+	if err != nil {
+		t.Fatal("AddressList(From) error:", err)
+	}
+	if len(from) != 1 || from[0].Name != "Alice" || from[0].Address != "alice@example.com" {
+		t.Errorf("AddressList(From) got: %v, want: Alice <alice@example.com>", from)
+	}
+	to, err := e.AddressList("To")
+	if err != nil {
+		t.Fatal("AddressList(To) error:", err)
+	}
+	if len(to) != 2 || to[1].Address != "carol@example.com" {
+		t.Errorf("AddressList(To) got: %v, want carol@example.com second", to)
+	}
+	if len(e.Errors) != 2 {
+		t.Errorf("Got %v e.Errors, want 2", len(e.Errors))
+	}
+}
+
+func TestParserRecoversPanic(t *testing.T) {
+	p := &Parser{PartFunc: func(*Part) error {
+		panic("boom")
+	}}
+	e, err := p.ReadEnvelope(openTestData("mail", "html-mime-inline.raw"))
+	if e != nil {
+		t.Errorf("Expected nil Envelope, got: %+v", e)
+	}
+	perr, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("Expected err to be *Error, got: %T %v", err, err)
+	}
+	if perr.Name != ErrorInternal || !perr.Severe {
+		t.Errorf("Got error %v, want severe %q", perr.String(), ErrorInternal)
+	}
+	if !strings.Contains(perr.Detail, "boom") {
+		t.Errorf("Error detail %q should contain the panic value", perr.Detail)
+	}
+
+	// A nil root causes a panic while building the Envelope
+	e, err = EnvelopeFromPart(nil)
+	if perr, ok := err.(*Error); e != nil || !ok || perr.Name != ErrorInternal {
+		t.Errorf("EnvelopeFromPart(nil) got: %v, %v, want severe %q", e, err, ErrorInternal)
+	}
+}
+
+// envelopeSummary describes the parsed content of e, for comparing the results of separate parses.
+func envelopeSummary(e *Envelope) string {
+	digests, err := e.AttachmentDigests()
+	return fmt.Sprintf("%q %q %v %v %v %v %v", e.Text, e.HTML, digests, err, len(e.Inlines),
+		len(e.OtherParts), e.Errors)
+}
+
+func TestParserConcurrent(t *testing.T) {
+	raw, err := ioutil.ReadAll(openTestData("mail", "html-mime-inline.raw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Parser{NormalizeLineEndings: true}
+	e, err := p.ReadEnvelope(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := envelopeSummary(e)
+
+	const goroutines = 20
+	results := make(chan string, goroutines)
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e, err := p.ReadEnvelope(bytes.NewReader(raw))
+			if err != nil {
+				results <- err.Error()
+				return
+			}
+			results <- envelopeSummary(e)
+		}()
+	}
+	wg.Wait()
+	close(results)
+	for got := range results {
+		if got != want {
+			t.Errorf("Concurrent parse got:\n%s\nwant:\n%s", got, want)
+		}
+	}
+}
+
+func BenchmarkParallelParse(b *testing.B) {
+	raw, err := ioutil.ReadAll(openTestData("mail", "html-mime-inline.raw"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(raw)))
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := ReadEnvelope(bytes.NewReader(raw)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
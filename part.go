@@ -3,32 +3,48 @@ package enmime
 import (
 	"bufio"
 	"bytes"
-	"encoding/base64"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"mime"
-	"mime/quotedprintable"
 	"net/textproto"
+	"path"
 	"strings"
+	"sync"
+	"unicode/utf8"
 )
 
 // Part represents a node in the MIME multipart tree.  The Content-Type, Disposition and File Name
 // are parsed out of the header for easier access.
 type Part struct {
-	Header      textproto.MIMEHeader // Header for this Part
-	Parent      *Part                // Parent of this part (can be nil)
-	FirstChild  *Part                // FirstChild is the top most child of this part
-	NextSibling *Part                // NextSibling of this part
-	ContentType string               // ContentType header without parameters
-	Disposition string               // Content-Disposition header without parameters
-	FileName    string               // The file-name from disposition or type header
-	Charset     string               // The content charset encoding label
-	Errors      []Error              // Errors encountered while parsing this part
-
-	boundary      string    // Boundary marker used within this part
-	rawReader     io.Reader // The raw Part content, no decoding or charset conversion
-	decodedReader io.Reader // The content decoded from quoted-printable or base64
-	utf8Reader    io.Reader // The decoded content converted to UTF-8
+	Header             textproto.MIMEHeader // Header for this Part
+	Parent             *Part                // Parent of this part (can be nil)
+	FirstChild         *Part                // FirstChild is the top most child of this part
+	NextSibling        *Part                // NextSibling of this part
+	ContentType        string               // ContentType header without parameters
+	Disposition        string               // Content-Disposition header without parameters
+	FileName           string               // The file-name from disposition, else the type header
+	ContentID          string               // Content-ID header without angle brackets
+	ContentLanguage    []string             // Language tags from the Content-Language header
+	ContentDescription string               // Content-Description header, decoded to UTF-8
+	Charset            string               // The content charset encoding label
+	SMIMEType          string               // The smime-type of an S/MIME pkcs7-mime part
+	Errors             []Error              // Errors encountered while parsing this part
+	Content            []byte               // Content after decoding, UTF-8 conversion if applicable
+	RawContent         []byte               // Exact bytes of a multipart/signed child, see Parser
+	TempPath           string               // File holding Content when spilled, see Parser
+	StartOffset        int64                // Offset of this part's header in the input
+	EndOffset          int64                // Offset of the end of this part's content in the input
+
+	boundary      string               // Boundary marker used within this part
+	ctypeParams   map[string]string    // Parameters of the Content-Type header
+	converted     bool                 // Content was converted from Charset to UTF-8
+	deflowed      bool                 // Content was rejoined from format=flowed text
+	rawHeader     []rawHeaderField     // Original header lines, in order
+	origHeader    textproto.MIMEHeader // Header values as originally parsed
+	rawReader     io.Reader            // The raw Part content, no decoding or charset conversion
+	decodedReader io.Reader            // The content decoded from quoted-printable or base64
+	utf8Reader    io.Reader            // The decoded content converted to UTF-8
 }
 
 // NewPart creates a new Part object.  It does not update the parents FirstChild attribute.
@@ -44,78 +60,325 @@ func (p *Part) Read(b []byte) (n int, err error) {
 	return p.utf8Reader.Read(b)
 }
 
+// TextContent returns Content as a UTF-8 string.  Parsed parts have already been converted from
+// their declared charset; for other parts the declared charset is used to convert Content.  An
+// error is returned if the part is not text.
+func (p *Part) TextContent() (string, error) {
+	if p.ContentType != "" && !strings.HasPrefix(p.ContentType, ctTextPrefix) {
+		return "", fmt.Errorf("Part with Content-Type %q is not text", p.ContentType)
+	}
+	if p.converted || p.Charset == "" {
+		return string(p.Content), nil
+	}
+	r, err := charsetReader(p.Charset, bytes.NewReader(p.Content))
+	if err != nil {
+		// Unsupported charset, return the content as-is
+		return string(p.Content), nil
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// Signature returns the signature part of a multipart/signed Part, which is always its second
+// child.  Returns nil if p is not multipart/signed.
+func (p *Part) Signature() *Part {
+	if p.ContentType != ctMultipartSigned || p.FirstChild == nil {
+		return nil
+	}
+	return p.FirstChild.NextSibling
+}
+
+// SignatureProtocol returns the protocol parameter of a multipart/signed Part, for example
+// "application/pgp-signature".  Returns an empty string if p is not multipart/signed.
+func (p *Part) SignatureProtocol() string {
+	if p.ContentType != ctMultipartSigned {
+		return ""
+	}
+	_, params, err := parseMediaType(p.Header.Get(hnContentType))
+	if err != nil {
+		return ""
+	}
+	return params[hpProtocol]
+}
+
+// ContentTypeParts returns the primary type and subtype of ContentType, ie "text" and "plain" for
+// text/plain.  Both are empty if ContentType is not set.
+func (p *Part) ContentTypeParts() (mainType, subType string) {
+	if i := strings.IndexByte(p.ContentType, '/'); i != -1 {
+		return p.ContentType[:i], p.ContentType[i+1:]
+	}
+	return p.ContentType, ""
+}
+
+// ContentTypeParams returns the parameters of the Content-Type header, such as charset, keyed by
+// lower case name.  Malformed headers are repaired where possible, as they are during parsing.
+// The returned map is cached and should not be modified.
+func (p *Part) ContentTypeParams() map[string]string {
+	if p.ctypeParams == nil {
+		_, p.ctypeParams, _ = parseMediaType(p.Header.Get(hnContentType))
+	}
+	return p.ctypeParams
+}
+
+// ContentTypeSource identifies where the result of Part.GuessedContentType was taken from.
+type ContentTypeSource string
+
+const (
+	// ContentTypeFromHeader indicates the declared Content-Type header was used.
+	ContentTypeFromHeader ContentTypeSource = "header"
+	// ContentTypeFromExtension indicates the type was derived from the file name extension.
+	ContentTypeFromExtension ContentTypeSource = "extension"
+)
+
+// GuessedContentType reconciles the declared content type with the one implied by the file name
+// extension, preferring a specific type over a missing or application/octet-stream declaration.
+// A specific declared type is always returned unchanged; when it conflicts with the extension an
+// ErrorContentTypeMismatch warning is recorded on the Part while parsing.
+func (p *Part) GuessedContentType() string {
+	ctype, _ := p.guessContentType()
+	return ctype
+}
+
+// GuessedContentTypeSource reports which source GuessedContentType used for its result.
+func (p *Part) GuessedContentTypeSource() ContentTypeSource {
+	_, source := p.guessContentType()
+	return source
+}
+
+// guessContentType implements GuessedContentType and GuessedContentTypeSource.
+func (p *Part) guessContentType() (string, ContentTypeSource) {
+	if p.ContentType != "" && p.ContentType != ctAppOctetStream {
+		return p.ContentType, ContentTypeFromHeader
+	}
+	if byExt := p.extensionContentType(); byExt != "" {
+		return byExt, ContentTypeFromExtension
+	}
+	return p.ContentType, ContentTypeFromHeader
+}
+
+// extensionContentType returns the media type implied by the extension of FileName, or an empty
+// string if the extension is missing, unknown or maps to application/octet-stream.
+func (p *Part) extensionContentType() string {
+	ext := path.Ext(p.FileName)
+	if ext == "" {
+		return ""
+	}
+	mtype, _, err := mime.ParseMediaType(mime.TypeByExtension(strings.ToLower(ext)))
+	if err != nil || mtype == ctAppOctetStream {
+		return ""
+	}
+	return mtype
+}
+
+// mediaTypeAliases maps common nonstandard media types, with any "x-" subtype prefix removed, to
+// the type mime.TypeByExtension reports for the same content.
+var mediaTypeAliases = map[string]string{
+	"application/javascript":     "text/javascript",
+	"application/zip-compressed": "application/zip",
+	"image/jpg":                  "image/jpeg",
+	"image/pjpeg":                "image/jpeg",
+	"text/xml":                   "application/xml",
+}
+
+// canonicalMediaType returns mediatype in a form suitable for comparing whether two media types
+// describe the same content.
+func canonicalMediaType(mediatype string) string {
+	mediatype = strings.Replace(strings.ToLower(mediatype), "/x-", "/", 1)
+	if alias, ok := mediaTypeAliases[mediatype]; ok {
+		return alias
+	}
+	return mediatype
+}
+
+// checkContentTypeMismatch records a warning on p when its declared Content-Type is specific, but
+// differs from the type implied by the file name extension, ie image/jpeg for "logo.png".  Such a
+// part may be mislabelled to evade content filtering.
+func (p *Part) checkContentTypeMismatch() {
+	if p.ContentType == "" || p.ContentType == ctAppOctetStream {
+		return
+	}
+	byExt := p.extensionContentType()
+	if byExt == "" || canonicalMediaType(byExt) == canonicalMediaType(p.ContentType) {
+		return
+	}
+	if exts, err := mime.ExtensionsByType(p.ContentType); err == nil {
+		for _, ext := range exts {
+			if strings.EqualFold(ext, path.Ext(p.FileName)) {
+				// The system considers the extension valid for the declared type
+				return
+			}
+		}
+	}
+	p.addWarning(
+		errorContentTypeMismatch,
+		"Content-Type %q does not match %q implied by file name %q",
+		p.ContentType, byExt, p.FileName)
+}
+
 // setupContentHeaders uses Content-Type media params and Content-Disposition headers to populate
-// the disposition, filename, and charset fields.
-func (p *Part) setupContentHeaders(mediaParams map[string]string) {
-	// Determine content disposition, filename, character set
+// the disposition, filename, and charset fields.  File names containing raw 8-bit bytes are
+// decoded from rawCharset, see Parser.RawHeaderCharset.
+func (p *Part) setupContentHeaders(mediaParams map[string]string, rawCharset string) {
+	p.ctypeParams = mediaParams
+	// Determine content disposition, filename, character set.  The file name is taken from the
+	// first of the disposition filename* and filename parameters, then the content type name* and
+	// name parameters, then the nonstandard content type file parameter.  The RFC 2231 extended
+	// forms take precedence within each header, see mime.ParseMediaType.
 	disposition, dparams, err := parseMediaType(p.Header.Get(hnContentDisposition))
 	if err == nil {
 		// Disposition is optional
 		p.Disposition = disposition
-		p.FileName = decodeHeader(dparams[hpFilename])
+		p.FileName = decodeHeaderCharset(dparams[hpFilename], rawCharset)
 	}
 	if p.FileName == "" && mediaParams[hpName] != "" {
-		p.FileName = decodeHeader(mediaParams[hpName])
+		p.FileName = decodeHeaderCharset(mediaParams[hpName], rawCharset)
 	}
 	if p.FileName == "" && mediaParams[hpFile] != "" {
-		p.FileName = decodeHeader(mediaParams[hpFile])
+		p.FileName = decodeHeaderCharset(mediaParams[hpFile], rawCharset)
 	}
 	if p.Charset == "" {
 		p.Charset = mediaParams[hpCharset]
 	}
+	p.ContentID = trimAngleBrackets(p.Header.Get(hnContentID))
+	p.SMIMEType = smimeType(p.ContentType, mediaParams)
+	p.checkContentTypeMismatch()
 }
 
-// buildContentReaders sets up the decodedReader and utf8Reader based on the Part headers.  If no
-// translation is required at a particular stage, the reader will be the same as its predecessor.
-// If the content encoding type is not recognized, no effort will be made to do character set
-// conversion.
-func (p *Part) buildContentReaders(r io.Reader) error {
+// Depth returns the number of ancestors of p; zero for the root of a Part tree.
+func (p *Part) Depth() int {
+	depth := 0
+	for a := p.Parent; a != nil; a = a.Parent {
+		depth++
+	}
+	return depth
+}
+
+// parseContentLanguage splits a comma separated Content-Language header value into its language
+// tags, omitting empty entries.  Returns nil for an empty value.
+func parseContentLanguage(value string) []string {
+	var tags []string
+	for _, tag := range strings.Split(value, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// trimAngleBrackets removes surrounding whitespace and angle brackets from a message or content ID.
+func trimAngleBrackets(id string) string {
+	id = strings.TrimSpace(id)
+	id = strings.TrimPrefix(id, "<")
+	return strings.TrimSuffix(id, ">")
+}
+
+// buildContentReaders sets up the decodedReader and utf8Reader based on the Part headers, and
+// populates Content.  If no translation is required at a particular stage, the reader will be the
+// same as its predecessor.  If the content encoding type is not recognized, no effort will be made
+// to do character set conversion.
+func (p *Part) buildContentReaders(r io.Reader, opts *Parser) error {
+	if opts.HeadersOnly {
+		// Content is not wanted, the caller may use the offsets to determine its size
+		_, err := io.Copy(ioutil.Discard, r)
+		return err
+	}
+
 	// Read raw content into buffer
+	if opts.MaxPartBytes > 0 {
+		r = io.LimitReader(r, opts.MaxPartBytes+1)
+	}
 	buf := new(bytes.Buffer)
-	if _, err := buf.ReadFrom(r); err != nil {
+	if _, err := buf.ReadFrom(r); err == io.ErrUnexpectedEOF {
+		// The closing boundary was never found, decode what content there is
+		p.addError(errorTruncatedMessage, "Message ended before the end of this part's content")
+	} else if err != nil {
 		return err
 	}
+	if opts.MaxPartBytes > 0 && int64(buf.Len()) > opts.MaxPartBytes {
+		p.addError(
+			errorLimitExceeded,
+			"Part content exceeded %v bytes, parsing stopped",
+			opts.MaxPartBytes)
+		return errLimitExceeded
+	}
 
 	var contentReader io.Reader = buf
+	var b64Cleaners []*base64Cleaner
 	valid := true
 
 	// Raw content reader
 	p.rawReader = contentReader
 
-	// Build content decoding reader
+	// Some mailers list multiple encodings, which is not permitted; the last is outermost
 	encoding := p.Header.Get(hnContentEncoding)
-	switch strings.ToLower(encoding) {
-	case "quoted-printable":
-		contentReader = newQPCleaner(contentReader)
-		contentReader = quotedprintable.NewReader(contentReader)
-	case "base64":
-		contentReader = newBase64Cleaner(contentReader)
-		contentReader = base64.NewDecoder(base64.StdEncoding, contentReader)
-	case "8bit", "7bit", "binary", "":
-		// No decoding required
-	default:
-		// Unknown encoding
-		valid = false
-		p.addWarning(
-			errorContentEncoding,
-			"Unrecognized Content-Transfer-Encoding type %q",
-			encoding)
+	if encoding == "" && opts.SniffTransferEncoding {
+		if encoding = sniffTransferEncoding(buf.Bytes()); encoding != "" {
+			p.addWarning(
+				errorContentEncoding,
+				"Content-Transfer-Encoding missing, content appears to be %q",
+				encoding)
+		}
+	}
+	encodings := []string{encoding}
+	if strings.Contains(encoding, ",") {
+		encodings = strings.Split(encoding, ",")
+		for i, j := 0, len(encodings)-1; i < j; i, j = i+1, j-1 {
+			encodings[i], encodings[j] = encodings[j], encodings[i]
+		}
+		for i := range encodings {
+			encodings[i] = strings.TrimSpace(encodings[i])
+		}
+		if opts.ChainTransferEncodings {
+			p.addWarning(
+				errorContentEncoding,
+				"Content-Transfer-Encoding %q lists multiple encodings, decoding each in turn",
+				encoding)
+		} else {
+			p.addWarning(
+				errorContentEncoding,
+				"Content-Transfer-Encoding %q lists multiple encodings, using %q",
+				encoding,
+				encodings[0])
+			encodings = encodings[:1]
+		}
+	}
+
+	// Build content decoding reader
+	for _, encoding := range encodings {
+		var b64Cleaner *base64Cleaner
+		contentReader, b64Cleaner, valid = newTransferReader(encoding, contentReader)
+		if b64Cleaner != nil {
+			b64Cleaners = append(b64Cleaners, b64Cleaner)
+		}
+		if !valid {
+			// Unknown encoding, content is passed through undecoded
+			p.addWarning(
+				errorContentEncoding,
+				"Unrecognized Content-Transfer-Encoding type %q",
+				encoding)
+			break
+		}
 	}
 	p.decodedReader = contentReader
 
 	if valid {
 		// decodedReader is good; build character set conversion reader
 		if p.Charset != "" {
-			if reader, err := newCharsetReader(p.Charset, contentReader); err == nil {
+			if reader, err := charsetReader(p.Charset, contentReader); err == nil {
 				contentReader = reader
+				p.converted = strings.ToLower(p.Charset) != "utf-8"
 			} else {
 				// Try to parse charset again here to see if we can salvage some badly formed ones
 				// like charset="charset=utf-8"
 				charsetp := strings.Split(p.Charset, "=")
 				if strings.ToLower(charsetp[0]) == "charset" && len(charsetp) > 1 {
 					p.Charset = charsetp[1]
-					if reader, err := newCharsetReader(p.Charset, contentReader); err == nil {
+					if reader, err := charsetReader(p.Charset, contentReader); err == nil {
 						contentReader = reader
+						p.converted = strings.ToLower(p.Charset) != "utf-8"
 					} else {
 						// Failed to get a conversion reader
 						p.addWarning(errorCharsetConversion, err.Error())
@@ -127,21 +390,109 @@ func (p *Part) buildContentReaders(r io.Reader) error {
 			}
 		}
 	}
-	p.utf8Reader = contentReader
+
+	// Decode content up front so that it may be accessed more than once, large binary content is
+	// written to a temporary file if requested
+	var content []byte
+	var err error
+	if opts.InMemoryThreshold > 0 && p.ContentType != "" &&
+		!strings.HasPrefix(p.ContentType, ctTextPrefix) {
+		content, err = p.spillContent(contentReader, opts.InMemoryThreshold, opts)
+	} else {
+		content, err = ioutil.ReadAll(contentReader)
+	}
+	if err != nil {
+		p.addError(errorContentEncoding, "Failed to decode content: %v", err)
+	}
+	if p.converted && bytes.ContainsRune(content, utf8.RuneError) {
+		// Bytes undefined in the charset are decoded as the replacement character
+		p.addWarning(
+			errorCharsetConversion,
+			"Content contained bytes that could not be converted from charset %q",
+			p.Charset)
+	}
+	for _, b64Cleaner := range b64Cleaners {
+		if b64Cleaner.repaired {
+			p.addWarning(
+				errorContentEncoding,
+				"Repaired base64 content containing invalid characters or missing padding")
+			break
+		}
+	}
+	if p.ContentType == ctTextPlain {
+		// Rejoin the soft line breaks of format=flowed body text, attachments are left as sent
+		params := p.ContentTypeParams()
+		disposition, _, _ := parseMediaType(p.Header.Get(hnContentDisposition))
+		if strings.EqualFold(params[hpFormat], "flowed") && disposition != cdAttachment {
+			content = deflow(content, strings.EqualFold(params[hpDelSp], "yes"))
+			p.deflowed = true
+		}
+	}
+	isText := p.ContentType == "" || strings.HasPrefix(p.ContentType, ctTextPrefix)
+	if opts.NormalizeLineEndings && isText {
+		content = normalizeLineEndings(content)
+	}
+	p.Content = content
+	if isText && err == nil {
+		p.checkDetectedCharset()
+	}
+	p.utf8Reader = bytes.NewReader(content)
+	if p.TempPath != "" {
+		p.utf8Reader = &tempFileReader{path: p.TempPath}
+	}
 	return nil
 }
 
+// normalizeLineEndings converts CRLF and lone CR line endings in b to LF.
+func normalizeLineEndings(b []byte) []byte {
+	b = bytes.Replace(b, []byte("\r\n"), []byte("\n"), -1)
+	return bytes.Replace(b, []byte("\r"), []byte("\n"), -1)
+}
+
 // ReadParts reads a MIME document from the provided reader and parses it into tree of Part objects.
+// The root Part is returned with its children linked, but without the collation ReadEnvelope
+// performs: no plain text is derived from HTML, and parts are not sorted into attachments and
+// inlines.  ReadEnvelope is equivalent to calling ReadParts followed by EnvelopeFromPart.
 func ReadParts(r io.Reader) (*Part, error) {
-	br := bufio.NewReader(r)
-	root := &Part{}
+	return new(Parser).ReadParts(r)
+}
+
+// readParts implements ReadParts, using the options specified in opts.  opts holds the state of
+// this parse, see Parser.parseState.
+func readParts(r io.Reader, opts *Parser) (root *Part, err error) {
+	opts.parts++
+
+	cr := &countingReader{r: r}
+	br := bufio.NewReader(cr)
+	if _, err := br.Peek(1); err == io.EOF {
+		return nil, ErrEmptyMessage
+	}
+	offset := func() int64 { return cr.n - int64(br.Buffered()) }
+	root = &Part{}
+	if hasBareCRLineEndings(br) {
+		// Translate to CRLF so that the header and boundaries may be found, offsets are
+		// approximate for such messages
+		root.addWarning(errorMalformedHeader, "Message uses bare CR line endings")
+		inner := br
+		br = bufio.NewReader(&bareCRReader{r: inner})
+		offset = func() int64 { return cr.n - int64(inner.Buffered()) - int64(br.Buffered()) }
+	}
 
 	// Read header
-	header, err := readHeader(br, root)
+	header, err := readHeader(br, root, opts)
 	if err != nil {
 		return nil, err
 	}
 	root.Header = header
+	root.ContentLanguage = parseContentLanguage(header.Get(hnContentLanguage))
+	root.ContentDescription = decodeHeaderCharset(
+		header.Get(hnContentDescription), opts.RawHeaderCharset)
+	if opts.DecodeQPSubject {
+		root.decodeQPSubject()
+	}
+	if opts.StripAddressRoutes {
+		root.stripAddressRoutes()
+	}
 
 	// Content-Type
 	contentType := header.Get(hnContentType)
@@ -156,27 +507,133 @@ func ReadParts(r io.Reader) (*Part, error) {
 	}
 	root.ContentType = mediatype
 	root.Charset = params[hpCharset]
+	root.ctypeParams = params
+	root.SMIMEType = smimeType(mediatype, params)
 
-	if strings.HasPrefix(mediatype, ctMultipartPrefix) {
+	boundary := params[hpBoundary]
+	if strings.HasPrefix(mediatype, ctMultipartPrefix) && boundary == "" {
+		root.addError(
+			errorMissingBoundaryParameter,
+			"Content-Type %q has no boundary parameter, content treated as a single body",
+			mediatype)
+	}
+	if strings.HasPrefix(mediatype, ctMultipartPrefix) && boundary != "" {
 		// Content is multipart, parse it
-		boundary := params[hpBoundary]
-		err = parseParts(root, br, boundary)
-		if err != nil {
-			return nil, err
+		var mr *bufio.Reader
+		var moffset func() int64
+		mr, moffset, err = root.decodeMultipart(br, offset, opts)
+		if err == nil {
+			err = parseParts(root, mr, moffset, boundary, opts)
+		}
+		if err == nil {
+			// Discard the epilogue so that EndOffset covers the entire input
+			_, err = io.Copy(ioutil.Discard, mr)
 		}
 	} else {
 		// Content is text or data, build content reader pipeline
-		if err := root.buildContentReaders(br); err != nil {
-			return nil, err
+		err = root.buildContentReaders(br, opts)
+	}
+	if err != nil && err != errLimitExceeded {
+		// A limit being exceeded is recorded in Errors, partial results are returned
+		return nil, err
+	}
+	root.EndOffset = offset()
+	if opts.PartFunc != nil {
+		if err := opts.PartFunc(root); err != nil {
+			return nil, &partFuncError{err}
 		}
 	}
 
 	return root, nil
 }
 
+// countingReader counts the bytes read from the underlying reader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+// Read method for io.Reader interface.
+func (c *countingReader) Read(p []byte) (n int, err error) {
+	n, err = c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// bareCRPeekLen is the number of bytes hasBareCRLineEndings examines.
+const bareCRPeekLen = 1024
+
+// hasBareCRLineEndings returns true if the first line ending of the buffered input is a lone CR,
+// as produced by some older Mac mail software.
+func hasBareCRLineEndings(r *bufio.Reader) bool {
+	buf, _ := r.Peek(bareCRPeekLen)
+	i := bytes.IndexAny(buf, "\r\n")
+	return i != -1 && i+1 < len(buf) && buf[i] == '\r' && buf[i+1] != '\n'
+}
+
+// bareCRReader translates lone CR line endings in the underlying reader to CRLF.
+type bareCRReader struct {
+	r *bufio.Reader
+}
+
+// Read method for io.Reader interface.
+func (c *bareCRReader) Read(p []byte) (n int, err error) {
+	// Leave room to follow each byte with an inserted LF
+	for n < len(p)-1 {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			if n > 0 {
+				return n, nil
+			}
+			return 0, err
+		}
+		p[n] = b
+		n++
+		if b == '\r' {
+			if next, err := c.r.Peek(1); err != nil || next[0] != '\n' {
+				p[n] = '\n'
+				n++
+			}
+		}
+	}
+	return n, nil
+}
+
+var (
+	mediaTypeFixersMu sync.RWMutex
+	mediaTypeFixers   []func(raw string) string
+)
+
+// RegisterMediaTypeFixer adds fn to the functions consulted before a Content-Type or
+// Content-Disposition value is parsed, allowing repairs for malformations enmime does not know
+// about, such as a particular appliance that emits "Content-Type: ;text/plain".  fn receives the
+// raw header value, or the output of the previously registered fixer, and returns the value to
+// parse.  Fixers run in registration order, before enmime's built-in repairs.
+func RegisterMediaTypeFixer(fn func(raw string) string) {
+	mediaTypeFixersMu.Lock()
+	defer mediaTypeFixersMu.Unlock()
+	mediaTypeFixers = append(mediaTypeFixers, fn)
+}
+
+// fixMediaType applies the registered media type fixers to ctype.
+func fixMediaType(ctype string) string {
+	mediaTypeFixersMu.RLock()
+	defer mediaTypeFixersMu.RUnlock()
+	for _, fn := range mediaTypeFixers {
+		ctype = fn(ctype)
+	}
+	return ctype
+}
+
 func parseMediaType(ctype string) (string, map[string]string, error) {
 	// Parse Content-Type header
+	ctype = convertRFC2231Charsets(fixMediaType(ctype))
 	mtype, mparams, err := mime.ParseMediaType(ctype)
+	if err != nil {
+		// Parameter values containing special characters, such as boundary=----=_Part_0, must be
+		// quoted but often are not
+		mtype, mparams, err = mime.ParseMediaType(quoteParamValues(ctype))
+	}
 	if err != nil {
 		// Small hack to remove harmless charset duplicate params
 		mctype := parseBadContentType(ctype, ";")
@@ -212,24 +669,60 @@ func parseBadContentType(ctype, sep string) string {
 	return mctype
 }
 
-// parseParts recursively parses a mime multipart document.
-func parseParts(parent *Part, reader *bufio.Reader, boundary string) error {
+// parseParts recursively parses a mime multipart document.  offset must return the input offset of
+// the next byte to be read from reader.
+func parseParts(parent *Part, reader *bufio.Reader, offset func() int64, boundary string,
+	opts *Parser) error {
 	var prevSibling *Part
 
 	// Loop over MIME parts
 	br := newBoundaryReader(reader, boundary)
+	brOffset := func() int64 { return offset() - int64(br.buffer.Len()) }
 	for {
 		next, err := br.Next()
 		if err != nil && err != io.EOF {
 			return err
 		}
+		if err == io.EOF && br.partsRead == 0 {
+			// The declared boundary never appeared
+			return recoverParts(parent, br.preamble.Bytes(), offset, boundary, opts)
+		}
 		if !next {
 			break
 		}
-		p := &Part{Parent: parent}
-		bbr := bufio.NewReader(br)
-		header, err := readHeader(bbr, p)
+		opts.parts++
+		if opts.MaxParts > 0 && opts.parts > opts.MaxParts {
+			parent.addError(
+				errorLimitExceeded,
+				"Message exceeded %v parts, parsing stopped",
+				opts.MaxParts)
+			return errLimitExceeded
+		}
+		p := &Part{Parent: parent, StartOffset: brOffset()}
+		if opts.NestingWarningDepth > 0 && !opts.nestingWarned {
+			if depth := p.Depth(); depth > opts.NestingWarningDepth {
+				p.addWarning(
+					errorExcessiveNesting,
+					"Part is nested %v levels deep, exceeding the threshold of %v",
+					depth,
+					opts.NestingWarningDepth)
+				opts.nestingWarned = true
+			}
+		}
+		var src io.Reader = br
+		var raw *bytes.Buffer
+		if opts.RawSignedContent && parent.ContentType == ctMultipartSigned {
+			// Retain the exact bytes of this part for signature verification
+			raw = new(bytes.Buffer)
+			src = io.TeeReader(br, raw)
+		}
+		bbr := bufio.NewReader(src)
+		bbrOffset := func() int64 { return brOffset() - int64(bbr.Buffered()) }
+		header, err := readHeader(bbr, p, opts)
 		p.Header = header
+		p.ContentLanguage = parseContentLanguage(header.Get(hnContentLanguage))
+		p.ContentDescription = decodeHeaderCharset(
+			header.Get(hnContentDescription), opts.RawHeaderCharset)
 		if err == errEmptyHeaderBlock {
 			// Empty header probably means the part didn't use the correct trailing "--" syntax to
 			// close its boundary.
@@ -237,11 +730,15 @@ func parseParts(parent *Part, reader *bufio.Reader, boundary string) error {
 				if err == io.EOF || strings.HasSuffix(err.Error(), "EOF") {
 					// There are no more Parts, but the error belongs to a sibling or parent,
 					// because this Part doesn't actually exist.
-					owner := parent
-					if prevSibling != nil {
-						owner = prevSibling
+					if prevSibling == nil {
+						// Not even one part was completed, the message was cut short
+						parent.addError(
+							errorTruncatedMessage,
+							"Message ended after the first boundary %q",
+							boundary)
+						break
 					}
-					owner.addWarning(
+					prevSibling.addWarning(
 						errorMissingBoundary,
 						"Boundary %q was not closed correctly",
 						boundary)
@@ -249,6 +746,13 @@ func parseParts(parent *Part, reader *bufio.Reader, boundary string) error {
 				}
 				return fmt.Errorf("Error at boundary %v: %v", boundary, err)
 			}
+		} else if err == io.ErrUnexpectedEOF {
+			// The input ended part way through the header, this Part is discarded
+			parent.addError(
+				errorTruncatedMessage,
+				"Message ended within the header of a part, before boundary %q was closed",
+				boundary)
+			break
 		} else if err != nil {
 			return err
 		}
@@ -267,8 +771,14 @@ func parseParts(parent *Part, reader *bufio.Reader, boundary string) error {
 			p.ContentType = mtype
 
 			// Set disposition, filename, charset if available
-			p.setupContentHeaders(mparams)
+			p.setupContentHeaders(mparams, opts.RawHeaderCharset)
 			p.boundary = mparams[hpBoundary]
+			if strings.HasPrefix(mtype, ctMultipartPrefix) && p.boundary == "" {
+				p.addError(
+					errorMissingBoundaryParameter,
+					"Content-Type %q has no boundary parameter, content treated as a single body",
+					mtype)
+			}
 		}
 
 		// Insert this Part into the MIME tree
@@ -281,17 +791,99 @@ func parseParts(parent *Part, reader *bufio.Reader, boundary string) error {
 
 		if p.boundary != "" {
 			// Content is another multipart
-			err = parseParts(p, bbr, p.boundary)
+			mr, moffset, err := p.decodeMultipart(bbr, bbrOffset, opts)
+			if err != nil {
+				return err
+			}
+			err = parseParts(p, mr, moffset, p.boundary, opts)
 			if err != nil {
 				return err
 			}
 		} else {
 			// Content is text or data: build content reader pipeline
-			if err := p.buildContentReaders(bbr); err != nil {
+			if err := p.buildContentReaders(bbr, opts); err != nil {
 				return err
 			}
 		}
+		// Consume anything remaining before the next boundary, such as a nested epilogue.  A
+		// missing boundary has already been recorded as truncation
+		if _, err := io.Copy(ioutil.Discard, bbr); err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		p.EndOffset = brOffset()
+		if raw != nil {
+			p.RawContent = raw.Bytes()
+		}
+		if opts.PartFunc != nil {
+			if err := opts.PartFunc(p); err != nil {
+				return &partFuncError{err}
+			}
+		}
 	}
 
 	return nil
 }
+
+// decodeMultipart returns a reader for the content of a multipart part.  RFC 2045 does not permit
+// multipart content to be encoded with base64 or quoted-printable, but some mailers do so; in that
+// case the content is decoded with a warning, and the offsets of the parts within refer to the end
+// of the encoded content.  The encoded content is subject to opts.MaxPartBytes.
+func (p *Part) decodeMultipart(r *bufio.Reader, offset func() int64, opts *Parser) (
+	*bufio.Reader, func() int64, error) {
+	encoding := strings.TrimSpace(p.Header.Get(hnContentEncoding))
+	switch strings.ToLower(encoding) {
+	case "8bit", "7bit", "binary", "":
+		return r, offset, nil
+	}
+	var raw io.Reader = r
+	if opts.MaxPartBytes > 0 {
+		raw = io.LimitReader(r, opts.MaxPartBytes+1)
+	}
+	cr := &countingReader{r: raw}
+	decoder, _, ok := newTransferReader(encoding, cr)
+	if !ok {
+		// Unknown encoding, the content is parsed as is
+		return r, offset, nil
+	}
+	p.addWarning(
+		errorEncodedMultipart,
+		"Multipart content has Content-Transfer-Encoding %q, decoding before parsing",
+		encoding)
+	content, err := ioutil.ReadAll(decoder)
+	if err != nil {
+		p.addError(errorContentEncoding, "Failed to decode multipart content: %v", err)
+	}
+	// Ensure the encoded content is consumed, even if decoding stopped early
+	_, _ = io.Copy(ioutil.Discard, cr)
+	if opts.MaxPartBytes > 0 && cr.n > opts.MaxPartBytes {
+		p.addError(
+			errorLimitExceeded,
+			"Part content exceeded %v bytes, parsing stopped",
+			opts.MaxPartBytes)
+		return nil, nil, errLimitExceeded
+	}
+	end := offset()
+	return bufio.NewReader(bytes.NewReader(content)), func() int64 { return end }, nil
+}
+
+// recoverParts attempts to parse body, the entire content of a multipart part, using the most
+// frequent boundary-like line in place of the declared boundary which was not found.
+func recoverParts(parent *Part, body []byte, offset func() int64, boundary string,
+	opts *Parser) error {
+	guess := guessBoundary(body)
+	if guess == "" || guess == boundary {
+		parent.addWarning(errorMissingBoundary, "Boundary %q was not found", boundary)
+		return nil
+	}
+	parent.addWarning(
+		errorBoundaryRecovered,
+		"Boundary %q was not found, parsed using %q instead",
+		boundary,
+		guess)
+	start := offset() - int64(len(body))
+	cr := &countingReader{r: bytes.NewReader(body)}
+	br := bufio.NewReader(cr)
+	return parseParts(parent, br, func() int64 {
+		return start + cr.n - int64(br.Buffered())
+	}, guess, opts)
+}
@@ -1,6 +1,9 @@
 package enmime
 
 import (
+	"net/textproto"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -100,6 +103,26 @@ func TestQuotedPrintableInvalidPart(t *testing.T) {
 	}
 }
 
+func TestQuotedPrintableLFSoftBreaks(t *testing.T) {
+	r := openTestData("parts", "quoted-printable-lf.raw")
+	p, err := ReadParts(r)
+	if err != nil {
+		t.Fatal("Unexpected parse error:", err)
+	}
+	if p == nil {
+		t.Fatal("Root node should not be nil")
+	}
+
+	want := "This line is long enough that it was wrapped by the sender with a soft line break, " +
+		"using only a line feed.\nEncoded bytes span the break: café\n"
+	if got := string(p.Content); got != want {
+		t.Errorf("Content got: %q, want: %q", got, want)
+	}
+	if len(p.Errors) != 0 {
+		t.Errorf("Got errors %v, want none", p.Errors)
+	}
+}
+
 func TestMultiAlternParts(t *testing.T) {
 	var want string
 	var wantp *Part
@@ -713,3 +736,934 @@ func TestBadBoundaryTerm(t *testing.T) {
 		t.Error("Part", err)
 	}
 }
+
+func TestSingleQuotedParams(t *testing.T) {
+	raw := "Content-Type: text/plain; charset='iso-8859-1'\r\n" +
+		"Content-Disposition: attachment; filename='a.txt'\r\n" +
+		"\r\n" +
+		"caf\xe9\r\n"
+	p, err := ReadParts(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Unexpected parse error:", err)
+	}
+
+	wantp := &Part{
+		ContentType: "text/plain",
+		Charset:     "iso-8859-1",
+	}
+	comparePart(p, wantp, func(field, got, want string) {
+		t.Errorf("Part.%s == %q, want: %q", field, got, want)
+	})
+	if len(p.Errors) != 2 {
+		t.Errorf("Got %v p.Errors, want 2", len(p.Errors))
+	}
+	for _, e := range p.Errors {
+		if e.Name != string(errorMalformedHeader) || e.Severe {
+			t.Errorf("Got error %v, want a %q warning", e.String(), errorMalformedHeader)
+		}
+	}
+
+	want := "caf\u00e9\r\n"
+	if ok, err := contentEqualsString(p, want); !ok {
+		t.Error("Part", err)
+	}
+}
+
+func TestRFC2231ParamsNotRepaired(t *testing.T) {
+	raw := "Content-Type: multipart/mixed; boundary=Enmime\r\n" +
+		"\r\n" +
+		"--Enmime\r\n" +
+		"Content-Type: application/octet-stream\r\n" +
+		"Content-Disposition: attachment; filename*=us-ascii'en-us'This%20is.txt\r\n" +
+		"\r\n" +
+		"data\r\n" +
+		"--Enmime--\r\n"
+	p, err := ReadParts(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Unexpected parse error:", err)
+	}
+	p = p.FirstChild
+	if p == nil {
+		t.Fatal("Child node should not be nil")
+	}
+
+	wantp := &Part{
+		Parent:      partExists,
+		ContentType: "application/octet-stream",
+		Disposition: "attachment",
+		FileName:    "This is.txt",
+	}
+	comparePart(p, wantp, func(field, got, want string) {
+		t.Errorf("Part.%s == %q, want: %q", field, got, want)
+	})
+	if len(p.Errors) != 0 {
+		t.Errorf("Got %v p.Errors, want 0", len(p.Errors))
+	}
+}
+
+func TestCommaSeparatedContentEncoding(t *testing.T) {
+	raw := "Content-Type: text/plain; charset=us-ascii\r\n" +
+		"Content-Transfer-Encoding: quoted-printable, base64\r\n" +
+		"\r\n" +
+		"YT0zRGI=\r\n"
+
+	testCases := []struct {
+		parser *Parser
+		want   string
+	}{
+		{&Parser{}, "a=3Db"},
+		{&Parser{ChainTransferEncodings: true}, "a=b"},
+	}
+	for _, tc := range testCases {
+		p, err := tc.parser.ReadParts(strings.NewReader(raw))
+		if err != nil {
+			t.Fatal("Unexpected parse error:", err)
+		}
+		if ok, err := contentEqualsString(p, tc.want); !ok {
+			t.Errorf("ChainTransferEncodings=%v: %v", tc.parser.ChainTransferEncodings, err)
+		}
+		if len(p.Errors) != 1 {
+			t.Fatalf("Got %v p.Errors, want 1", len(p.Errors))
+		}
+		if p.Errors[0].Name != ErrorContentEncoding {
+			t.Errorf("Got error %q, want %q", p.Errors[0].Name, ErrorContentEncoding)
+		}
+	}
+}
+
+func TestRawSignedContent(t *testing.T) {
+	signed := "Content-Type: text/plain; charset=us-ascii\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"Signed  =\r\n" +
+		"text \r\n"
+	raw := "Content-Type: multipart/signed; boundary=Enmime;\r\n" +
+		"\tprotocol=\"application/pgp-signature\"; micalg=pgp-sha256\r\n" +
+		"\r\n" +
+		"--Enmime\r\n" +
+		signed +
+		"\r\n" +
+		"--Enmime\r\n" +
+		"Content-Type: application/pgp-signature; name=\"signature.asc\"\r\n" +
+		"\r\n" +
+		"-----BEGIN PGP SIGNATURE-----\r\n" +
+		"-----END PGP SIGNATURE-----\r\n" +
+		"\r\n" +
+		"--Enmime--\r\n"
+
+	p, err := (&Parser{RawSignedContent: true}).ReadParts(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Unexpected parse error:", err)
+	}
+	if got, want := p.SignatureProtocol(), "application/pgp-signature"; got != want {
+		t.Errorf("SignatureProtocol() == %q, want: %q", got, want)
+	}
+	if p.FirstChild == nil {
+		t.Fatal("Child node should not be nil")
+	}
+	if got := string(p.FirstChild.RawContent); got != signed {
+		t.Errorf("RawContent\ngot : %q\nwant: %q", got, signed)
+	}
+	if ok, err := contentEqualsString(p.FirstChild, "Signed  text\r\n"); !ok {
+		t.Error("Part", err)
+	}
+	sig := p.Signature()
+	if sig == nil {
+		t.Fatal("Signature() should not be nil")
+	}
+	if sig.ContentType != "application/pgp-signature" {
+		t.Errorf("Signature().ContentType == %q, want: %q", sig.ContentType,
+			"application/pgp-signature")
+	}
+
+	// RawContent is only retained when requested
+	p, err = ReadParts(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Unexpected parse error:", err)
+	}
+	if p.FirstChild.RawContent != nil {
+		t.Error("RawContent should be nil by default")
+	}
+}
+
+func TestGuessedContentType(t *testing.T) {
+	testCases := []struct {
+		ctype, filename string
+		want            string
+		source          ContentTypeSource
+	}{
+		{"application/octet-stream", "invoice.pdf", "application/pdf", ContentTypeFromExtension},
+		{"", "INVOICE.PDF", "application/pdf", ContentTypeFromExtension},
+		{"image/png", "logo.png", "image/png", ContentTypeFromHeader},
+		{"image/jpeg", "logo.png", "image/jpeg", ContentTypeFromHeader},
+		{"application/octet-stream", "data", "application/octet-stream", ContentTypeFromHeader},
+	}
+	for _, tc := range testCases {
+		p := &Part{ContentType: tc.ctype, FileName: tc.filename}
+		if got := p.GuessedContentType(); got != tc.want {
+			t.Errorf("GuessedContentType() for %q, %q == %q, want: %q",
+				tc.ctype, tc.filename, got, tc.want)
+		}
+		if got := p.GuessedContentTypeSource(); got != tc.source {
+			t.Errorf("GuessedContentTypeSource() for %q, %q == %q, want: %q",
+				tc.ctype, tc.filename, got, tc.source)
+		}
+	}
+}
+
+func TestContentTypeMismatch(t *testing.T) {
+	raw := "Content-Type: multipart/mixed; boundary=Enmime\r\n" +
+		"\r\n" +
+		"--Enmime\r\n" +
+		"Content-Type: image/jpeg\r\n" +
+		"Content-Disposition: attachment; filename=logo.png\r\n" +
+		"\r\n" +
+		"PNG\r\n" +
+		"--Enmime\r\n" +
+		"Content-Type: image/png; name=logo.PNG\r\n" +
+		"\r\n" +
+		"PNG\r\n" +
+		"--Enmime\r\n" +
+		"Content-Type: application/octet-stream; name=invoice.pdf\r\n" +
+		"\r\n" +
+		"PDF\r\n" +
+		"--Enmime--\r\n"
+	p, err := ReadParts(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Unexpected parse error:", err)
+	}
+
+	mislabelled := p.FirstChild
+	if len(mislabelled.Errors) != 1 || mislabelled.Errors[0].Name != ErrorContentTypeMismatch ||
+		mislabelled.Errors[0].Severe {
+		t.Errorf("Mislabelled part got errors %v, want a %q warning", mislabelled.Errors,
+			ErrorContentTypeMismatch)
+	}
+	for _, c := range []*Part{mislabelled.NextSibling, mislabelled.NextSibling.NextSibling} {
+		if len(c.Errors) != 0 {
+			t.Errorf("Part %q got unexpected errors: %v", c.FileName, c.Errors)
+		}
+	}
+}
+
+func TestPartTextContent(t *testing.T) {
+	testCases := []struct {
+		raw  string
+		want string
+	}{
+		{
+			"Content-Type: text/plain; charset=iso-8859-1\r\n\r\nCaf\xe9 cr\xe8me",
+			"Café crème",
+		},
+		{
+			"Content-Type: text/plain; charset=utf-8\r\n\r\nCafé crème",
+			"Café crème",
+		},
+	}
+	for _, tc := range testCases {
+		p, err := ReadParts(strings.NewReader(tc.raw))
+		if err != nil {
+			t.Fatal("Unexpected parse error:", err)
+		}
+		got, err := p.TextContent()
+		if err != nil {
+			t.Fatal("Unexpected TextContent error:", err)
+		}
+		if got != tc.want {
+			t.Errorf("TextContent() == %q, want: %q", got, tc.want)
+		}
+	}
+
+	// Constructed parts are converted using their declared charset
+	p := NewPart(nil, "text/plain")
+	p.Charset = "iso-8859-1"
+	p.Content = []byte("Caf\xe9")
+	if got, err := p.TextContent(); err != nil || got != "Café" {
+		t.Errorf("TextContent() == %q, %v, want: %q", got, err, "Café")
+	}
+
+	p = NewPart(nil, "image/png")
+	if _, err := p.TextContent(); err == nil {
+		t.Error("TextContent() for image/png should return an error")
+	}
+}
+
+func TestNormalizeLineEndings(t *testing.T) {
+	raw := "Content-Type: multipart/mixed; boundary=Enmime\r\n" +
+		"\r\n" +
+		"--Enmime\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"one\r\ntwo\rthree\n" +
+		"--Enmime\r\n" +
+		"Content-Type: application/octet-stream\r\n" +
+		"\r\n" +
+		"bin\r\nary\r\n" +
+		"--Enmime--\r\n"
+
+	testCases := []struct {
+		normalize  bool
+		text, data string
+	}{
+		{false, "one\r\ntwo\rthree", "bin\r\nary"},
+		{true, "one\ntwo\nthree", "bin\r\nary"},
+	}
+	for _, tc := range testCases {
+		p, err := (&Parser{NormalizeLineEndings: tc.normalize}).ReadParts(strings.NewReader(raw))
+		if err != nil {
+			t.Fatal("Unexpected parse error:", err)
+		}
+		text := p.FirstChild
+		if got := string(text.Content); got != tc.text {
+			t.Errorf("NormalizeLineEndings=%v text got: %q, want: %q", tc.normalize, got, tc.text)
+		}
+		data := text.NextSibling
+		if got := string(data.Content); got != tc.data {
+			t.Errorf("NormalizeLineEndings=%v binary got: %q, want: %q", tc.normalize, got, tc.data)
+		}
+	}
+}
+
+func TestBoundaryTrailingWhitespace(t *testing.T) {
+	r := openTestData("parts", "boundary-whitespace.raw")
+	p, err := ReadParts(r)
+	if err != nil {
+		t.Fatal("Unexpected parse error:", err)
+	}
+
+	var children []*Part
+	for c := p.FirstChild; c != nil; c = c.NextSibling {
+		children = append(children, c)
+	}
+	if len(children) != 2 {
+		t.Fatalf("Got %v child parts, want 2", len(children))
+	}
+	for i, want := range []string{"Section one", "Section two"} {
+		if ok, err := contentEqualsString(children[i], want); !ok {
+			t.Errorf("Child %v: %v", i, err)
+		}
+	}
+	for _, part := range append(children, p) {
+		for _, perr := range part.Errors {
+			if perr.Name == ErrorMissingBoundary {
+				t.Errorf("Unexpected error: %v", perr.String())
+			}
+		}
+	}
+}
+
+func TestPartOffsets(t *testing.T) {
+	raw := "Content-Type: multipart/mixed; boundary=XX\r\n" +
+		"\r\n" +
+		"preamble\r\n" +
+		"--XX\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"one\r\n" +
+		"--XX\r\n" +
+		"Content-Type: multipart/alternative; boundary=YY\r\n" +
+		"\r\n" +
+		"--YY\r\n" +
+		"Content-Type: text/html\r\n" +
+		"\r\n" +
+		"<b>two</b>\r\n" +
+		"--YY--\r\n" +
+		"\r\n" +
+		"--XX--\r\n" +
+		"epilogue\r\n"
+	p, err := ReadParts(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Parts start after the delimiter line, and end at the CRLF preceding the next boundary
+	text := p.FirstChild
+	alt := text.NextSibling
+	html := alt.FirstChild
+	testCases := []struct {
+		name       string
+		part       *Part
+		start, end string
+	}{
+		{"text", text, "Content-Type: text/plain", "\r\n--XX\r\nContent-Type: multipart"},
+		{"alternative", alt, "Content-Type: multipart/alternative", "\r\n--XX--"},
+		{"html", html, "Content-Type: text/html", "\r\n--YY--"},
+	}
+	for _, tc := range testCases {
+		if !strings.HasPrefix(raw[tc.part.StartOffset:], tc.start) {
+			t.Errorf("%s StartOffset %v points at %q, want %q", tc.name, tc.part.StartOffset,
+				raw[tc.part.StartOffset:], tc.start)
+		}
+		if !strings.HasPrefix(raw[tc.part.EndOffset:], tc.end) {
+			t.Errorf("%s EndOffset %v points at %q, want %q", tc.name, tc.part.EndOffset,
+				raw[tc.part.EndOffset:], tc.end)
+		}
+	}
+	if p.StartOffset != 0 || p.EndOffset != int64(len(raw)) {
+		t.Errorf("Root offsets got: %v-%v, want: 0-%v", p.StartOffset, p.EndOffset, len(raw))
+	}
+}
+
+func TestContentTypeParts(t *testing.T) {
+	testCases := []struct {
+		ctype             string
+		mainType, subType string
+		charset, fileName string
+	}{
+		{"text/plain; charset=utf-8", "text", "plain", "utf-8", ""},
+		{"Image/PNG; name=logo.png", "image", "png", "", "logo.png"},
+		// Missing semicolon, fixed by the repair parser
+		{"text/html charset=iso-8859-1 name=page.html", "text", "html", "iso-8859-1", "page.html"},
+	}
+	for _, tc := range testCases {
+		raw := "Content-Type: " + tc.ctype + "\r\n\r\nBody\r\n"
+		p, err := ReadParts(strings.NewReader(raw))
+		if err != nil {
+			t.Fatal(tc.ctype, err)
+		}
+		mainType, subType := p.ContentTypeParts()
+		if mainType != tc.mainType || subType != tc.subType {
+			t.Errorf("%q ContentTypeParts() got: %q, %q, want: %q, %q",
+				tc.ctype, mainType, subType, tc.mainType, tc.subType)
+		}
+		params := p.ContentTypeParams()
+		if params[hpCharset] != tc.charset || params[hpName] != tc.fileName {
+			t.Errorf("%q ContentTypeParams() got: %v", tc.ctype, params)
+		}
+	}
+
+	// Parts not produced by the parser compute their parameters on demand
+	p := NewPart(nil, "multipart/mixed")
+	p.Header = textproto.MIMEHeader{"Content-Type": []string{"multipart/mixed; boundary=XX"}}
+	if got := p.ContentTypeParams()[hpBoundary]; got != "XX" {
+		t.Errorf("ContentTypeParams() boundary got: %q, want: %q", got, "XX")
+	}
+	if mainType, subType := (&Part{}).ContentTypeParts(); mainType != "" || subType != "" {
+		t.Errorf("ContentTypeParts() of empty Part got: %q, %q", mainType, subType)
+	}
+}
+
+// partTree describes the content types of p and its descendants, ie "multipart/mixed[text/plain]".
+func partTree(p *Part) string {
+	s := p.ContentType
+	if p.FirstChild == nil {
+		return s
+	}
+	var children []string
+	for c := p.FirstChild; c != nil; c = c.NextSibling {
+		children = append(children, partTree(c))
+	}
+	return s + "[" + strings.Join(children, " ") + "]"
+}
+
+func TestReadPartsTree(t *testing.T) {
+	testCases := []struct {
+		file, want string
+	}{
+		{
+			"mime-mixed.raw",
+			"multipart/mixed[text/plain text/plain]",
+		},
+		{
+			"html-mime-inline.raw",
+			"multipart/alternative[text/plain multipart/related[text/html image/png]]",
+		},
+		{
+			"html-only-inline.raw",
+			"multipart/alternative[multipart/related[text/html image/png]]",
+		},
+		{
+			"attachment-only.raw",
+			"image/jpeg",
+		},
+		{
+			"non-mime.raw",
+			"",
+		},
+		{
+			"mime-alternative.raw",
+			"multipart/alternative[text/plain text/plain]",
+		},
+		{
+			"dsn.raw",
+			"multipart/report[text/plain message/delivery-status text/rfc822-headers]",
+		},
+	}
+	for _, tc := range testCases {
+		root, err := ReadParts(openTestData("mail", tc.file))
+		if err != nil {
+			t.Fatal(tc.file, err)
+		}
+		if got := partTree(root); got != tc.want {
+			t.Errorf("%s tree got: %q, want: %q", tc.file, got, tc.want)
+		}
+		if root.Parent != nil {
+			t.Errorf("%s root has a Parent", tc.file)
+		}
+		for _, p := range root.DepthMatchAll(func(p *Part) bool { return p != root }) {
+			found := false
+			for c := p.Parent.FirstChild; c != nil; c = c.NextSibling {
+				found = found || c == p
+			}
+			if !found {
+				t.Errorf("%s %v part is not a child of its Parent", tc.file, p.ContentType)
+			}
+		}
+	}
+}
+
+func TestContentLanguage(t *testing.T) {
+	raw := "Content-Type: multipart/alternative; boundary=Lang\r\n" +
+		"Content-Language: en-US, fr\r\n" +
+		"\r\n" +
+		"--Lang\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"Content-Language: fr , ,\r\n" +
+		"\r\n" +
+		"Bonjour\r\n" +
+		"--Lang\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"Hello\r\n" +
+		"--Lang--\r\n"
+	p, err := ReadParts(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		part *Part
+		want []string
+	}{
+		{p, []string{"en-US", "fr"}},
+		{p.FirstChild, []string{"fr"}},
+		{p.FirstChild.NextSibling, nil},
+	}
+	for i, tc := range testCases {
+		if !reflect.DeepEqual(tc.part.ContentLanguage, tc.want) {
+			t.Errorf("Part %v ContentLanguage got: %q, want: %q", i, tc.part.ContentLanguage, tc.want)
+		}
+	}
+}
+
+func TestContentDescription(t *testing.T) {
+	raw := "Content-Type: multipart/mixed; boundary=Desc\r\n" +
+		"\r\n" +
+		"--Desc\r\n" +
+		"Content-Type: application/pdf\r\n" +
+		"Content-Description: =?UTF-8?Q?Rapport_annuel_=E2=80=93_2017?=\r\n" +
+		"\r\n" +
+		"PDF\r\n" +
+		"--Desc\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"Content-Description: Plain\r\n" +
+		" notes\r\n" +
+		"\r\n" +
+		"Notes\r\n" +
+		"--Desc--\r\n"
+	p, err := ReadParts(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		part *Part
+		want string
+	}{
+		{p, ""},
+		{p.FirstChild, "Rapport annuel – 2017"},
+		{p.FirstChild.NextSibling, "Plain notes"},
+	}
+	for i, tc := range testCases {
+		if tc.part.ContentDescription != tc.want {
+			t.Errorf("Part %v ContentDescription got: %q, want: %q", i,
+				tc.part.ContentDescription, tc.want)
+		}
+	}
+}
+
+func TestBareCRLineEndings(t *testing.T) {
+	raw := "From: James <james@example.com>\r" +
+		"Subject: Old Mac\r" +
+		"Content-Type: multipart/mixed; boundary=Mac\r" +
+		"\r" +
+		"--Mac\r" +
+		"Content-Type: text/plain\r" +
+		"\r" +
+		"one\rtwo\r" +
+		"--Mac--\r"
+	p, err := ReadParts(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Unexpected parse error:", err)
+	}
+	want := map[string]string{
+		"From":         "James <james@example.com>",
+		"Subject":      "Old Mac",
+		"Content-Type": "multipart/mixed; boundary=Mac",
+	}
+	for name, value := range want {
+		if got := p.Header.Get(name); got != value {
+			t.Errorf("Header %q got: %q, want: %q", name, got, value)
+		}
+	}
+	if p.FirstChild == nil {
+		t.Fatal("Root has no child parts")
+	}
+	if ok, err := contentEqualsString(p.FirstChild, "one\r\ntwo"); !ok {
+		t.Error(err)
+	}
+	if len(p.Errors) != 1 || p.Errors[0].Name != ErrorMalformedHeader {
+		t.Errorf("Got errors %v, want a single %q warning", p.Errors, ErrorMalformedHeader)
+	}
+
+	// CRLF input must not be translated
+	p, err = ReadParts(strings.NewReader(strings.Replace(raw, "\r", "\r\n", -1)))
+	if err != nil {
+		t.Fatal("Unexpected parse error:", err)
+	}
+	if len(p.Errors) != 0 {
+		t.Errorf("Got errors %v for CRLF input, want none", p.Errors)
+	}
+}
+
+func TestBoundaryRecovered(t *testing.T) {
+	raw := "Content-Type: multipart/mixed; boundary=Declared\r\n" +
+		"\r\n" +
+		"Preamble\r\n" +
+		"--Actual\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"Section one\r\n" +
+		"--Actual\r\n" +
+		"Content-Type: text/html\r\n" +
+		"\r\n" +
+		"<p>Section two</p>\r\n" +
+		"--Actual--\r\n"
+	p, err := ReadParts(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Unexpected parse error:", err)
+	}
+
+	want := []string{"Section one", "<p>Section two</p>"}
+	var got []string
+	for c := p.FirstChild; c != nil; c = c.NextSibling {
+		got = append(got, string(c.Content))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Child content got: %q, want: %q", got, want)
+	}
+	if len(p.Errors) != 1 || p.Errors[0].Name != ErrorBoundaryRecovered {
+		t.Errorf("Got errors %v, want a single %q warning", p.Errors, ErrorBoundaryRecovered)
+	}
+	if p.FirstChild != nil && raw[p.FirstChild.StartOffset:p.FirstChild.EndOffset] !=
+		"Content-Type: text/plain\r\n\r\nSection one" {
+		t.Errorf("Recovered part offsets %v-%v do not match input",
+			p.FirstChild.StartOffset, p.FirstChild.EndOffset)
+	}
+
+	// Without any usable boundary the content is lost, but a warning is recorded
+	raw = "Content-Type: multipart/mixed; boundary=Declared\r\n\r\nJust text\r\n"
+	p, err = ReadParts(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Unexpected parse error:", err)
+	}
+	if p.FirstChild != nil {
+		t.Error("Got child part, want none")
+	}
+	if len(p.Errors) != 1 || p.Errors[0].Name != ErrorMissingBoundary {
+		t.Errorf("Got errors %v, want a single %q warning", p.Errors, ErrorMissingBoundary)
+	}
+}
+
+func TestEncodedMultipart(t *testing.T) {
+	r := openTestData("parts", "encoded-multipart.raw")
+	p, err := ReadParts(r)
+	if err != nil {
+		t.Fatal("Unexpected parse error:", err)
+	}
+
+	want := "multipart/mixed[multipart/alternative[text/plain text/html] application/octet-stream]"
+	if got := partTree(p); got != want {
+		t.Errorf("Part tree got: %q, want: %q", got, want)
+	}
+
+	alt := p.FirstChild
+	if alt == nil || alt.FirstChild == nil || alt.FirstChild.NextSibling == nil {
+		t.Fatal("Encoded multipart was not parsed")
+	}
+	if ok, err := contentEqualsString(alt.FirstChild, "Hello from inside"); !ok {
+		t.Error("Inner text/plain:", err)
+	}
+	if ok, err := contentEqualsString(alt.FirstChild.NextSibling, "<p>Hello from inside</p>"); !ok {
+		t.Error("Inner text/html:", err)
+	}
+	if len(alt.Errors) != 1 || alt.Errors[0].Name != ErrorEncodedMultipart {
+		t.Errorf("Got errors %v, want a single %q warning", alt.Errors, ErrorEncodedMultipart)
+	}
+	if ok, err := contentEqualsString(alt.NextSibling, "data"); !ok {
+		t.Error("Attachment following encoded multipart:", err)
+	}
+}
+
+func TestEncodedMultipartMaxPartBytes(t *testing.T) {
+	r := openTestData("parts", "encoded-multipart.raw")
+	p, err := (&Parser{MaxPartBytes: 100}).ReadParts(r)
+	if err != nil {
+		t.Fatal("Unexpected parse error:", err)
+	}
+
+	alt := p.FirstChild
+	if alt == nil {
+		t.Fatal("Encoded multipart part missing")
+	}
+	if alt.FirstChild != nil {
+		t.Error("Encoded multipart exceeding MaxPartBytes should not be parsed")
+	}
+	if len(alt.Errors) != 2 || alt.Errors[1].Name != ErrorLimitExceeded || !alt.Errors[1].Severe {
+		t.Errorf("Got errors %v, want a severe %q error", alt.Errors, ErrorLimitExceeded)
+	}
+}
+
+func TestFileNamePrecedence(t *testing.T) {
+	testCases := []struct {
+		name, ctype, disposition, want string
+	}{
+		{
+			"disposition filename over type name",
+			`text/plain; name="a.txt"`,
+			`attachment; filename="b.txt"`,
+			"b.txt",
+		},
+		{
+			"disposition filename* over filename",
+			`text/plain; name="a.txt"`,
+			`attachment; filename="b.txt"; filename*=UTF-8''c%C3%A9.txt`,
+			"cé.txt",
+		},
+		{
+			"disposition filename* in Latin-1",
+			`text/plain; name="a.txt"`,
+			`attachment; filename*=iso-8859-1'fr'caf%E9.txt`,
+			"café.txt",
+		},
+		{
+			"continued filename* in Latin-1",
+			`text/plain`,
+			`attachment; filename*0*=iso-8859-1''caf%E9; filename*1*=%E9.txt`,
+			"caféé.txt",
+		},
+		{
+			"type name* over name",
+			`text/plain; name="a.txt"; name*=iso-8859-1''d%E9.txt`,
+			`attachment`,
+			"dé.txt",
+		},
+		{
+			"type name without disposition",
+			`text/plain; name="a.txt"`,
+			``,
+			"a.txt",
+		},
+	}
+	for _, tc := range testCases {
+		raw := "Content-Type: " + tc.ctype + "\r\n"
+		if tc.disposition != "" {
+			raw += "Content-Disposition: " + tc.disposition + "\r\n"
+		}
+		raw = "Content-Type: multipart/mixed; boundary=Enmime\r\n\r\n--Enmime\r\n" + raw +
+			"\r\ndata\r\n--Enmime--\r\n"
+		p, err := ReadParts(strings.NewReader(raw))
+		if err != nil {
+			t.Fatalf("%s: Unexpected parse error: %v", tc.name, err)
+		}
+		if p.FirstChild == nil {
+			t.Fatalf("%s: Child node should not be nil", tc.name)
+		}
+		if got := p.FirstChild.FileName; got != tc.want {
+			t.Errorf("%s: FileName got: %q, want: %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestEmptyMessage(t *testing.T) {
+	if _, err := ReadEnvelope(strings.NewReader("")); err != ErrEmptyMessage {
+		t.Errorf("ReadEnvelope() error got: %v, want: %v", err, ErrEmptyMessage)
+	}
+	if _, err := ReadParts(strings.NewReader("")); err != ErrEmptyMessage {
+		t.Errorf("ReadParts() error got: %v, want: %v", err, ErrEmptyMessage)
+	}
+}
+
+func TestTruncatedMessage(t *testing.T) {
+	header := "Content-Type: multipart/mixed; boundary=Enmime\r\n\r\n"
+	testCases := []struct {
+		name, raw, text string
+	}{
+		{"after first boundary", header + "--Enmime\r\n", ""},
+		{"within part header", header + "--Enmime\r\nContent-Type: text/pl", ""},
+		{"within part content", header + "--Enmime\r\nContent-Type: text/plain\r\n\r\nHel", "Hel"},
+	}
+	for _, tc := range testCases {
+		e, err := ReadEnvelope(strings.NewReader(tc.raw))
+		if err != nil {
+			t.Errorf("%s: Unexpected parse error: %v", tc.name, err)
+			continue
+		}
+		if e.Text != tc.text {
+			t.Errorf("%s: Text got: %q, want: %q", tc.name, e.Text, tc.text)
+		}
+		found := false
+		for _, perr := range e.Errors {
+			if perr.Name == ErrorTruncatedMessage {
+				found = true
+				if !perr.Severe {
+					t.Errorf("%s: %q error is not severe", tc.name, perr.Name)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("%s: Got errors %v, want a %q error", tc.name, e.Errors, ErrorTruncatedMessage)
+		}
+	}
+}
+
+func TestMissingBoundaryParameter(t *testing.T) {
+	raw := "Content-Type: multipart/mixed; boundary=Enmime\r\n" +
+		"\r\n" +
+		"--Enmime\r\n" +
+		"Content-Type: multipart/mixed\r\n" +
+		"\r\n" +
+		"--Inner\r\nContent-Type: text/plain\r\n\r\nLost\r\n--Inner--\r\n" +
+		"--Enmime\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"Hello\r\n" +
+		"--Enmime--\r\n"
+	e, err := ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Unexpected parse error:", err)
+	}
+	if e.Text != "Hello" {
+		t.Errorf("Text got: %q, want: %q", e.Text, "Hello")
+	}
+	p := e.Root.FirstChild
+	if p == nil || p.ContentType != "multipart/mixed" {
+		t.Fatalf("Root.FirstChild got: %+v, want a multipart/mixed part", p)
+	}
+	if p.FirstChild != nil {
+		t.Error("Part without boundary has children, want none")
+	}
+	if !strings.Contains(string(p.Content), "Lost") {
+		t.Errorf("Content got: %q, want the undivided body", p.Content)
+	}
+	if len(p.Errors) != 1 || p.Errors[0].Name != ErrorMissingBoundaryParameter ||
+		!p.Errors[0].Severe {
+		t.Errorf("Got errors %v, want a single severe %q error", p.Errors,
+			ErrorMissingBoundaryParameter)
+	}
+
+	// The same applies to the root part
+	root, err := ReadParts(strings.NewReader("Content-Type: multipart/mixed\r\n\r\nBody\r\n"))
+	if err != nil {
+		t.Fatal("Unexpected parse error:", err)
+	}
+	if string(root.Content) != "Body\r\n" {
+		t.Errorf("Root Content got: %q, want: %q", root.Content, "Body\r\n")
+	}
+	if len(root.Errors) != 1 || root.Errors[0].Code != ErrCodeMissingBoundaryParameter {
+		t.Errorf("Got root errors %v, want a single %q error", root.Errors,
+			ErrorMissingBoundaryParameter)
+	}
+	e, err = ReadEnvelope(strings.NewReader("Content-Type: multipart/mixed\r\n\r\nBody\r\n"))
+	if err != nil {
+		t.Fatal("Unexpected error building Envelope:", err)
+	}
+	if e.Text != "Body\r\n" {
+		t.Errorf("Envelope Text got: %q, want: %q", e.Text, "Body\r\n")
+	}
+}
+
+func TestBoundarySpecialCharacters(t *testing.T) {
+	testCases := []struct {
+		ctype, boundary string
+	}{
+		{`multipart/mixed; boundary="----=_Part_0_12345"`, "----=_Part_0_12345"},
+		{"multipart/mixed; boundary=----=_Part_0_12345", "----=_Part_0_12345"},
+		{`multipart/mixed; boundary="=_a?b:c(d)/e,f"`, "=_a?b:c(d)/e,f"},
+		{`multipart/mixed;boundary="----=_NextPart_000_0001_01D2.ABCD"; type="text/plain"`,
+			"----=_NextPart_000_0001_01D2.ABCD"},
+	}
+	for _, tc := range testCases {
+		raw := "Content-Type: " + tc.ctype + "\r\n" +
+			"\r\n" +
+			"--" + tc.boundary + "\r\n" +
+			"Content-Type: text/plain\r\n" +
+			"\r\n" +
+			"one\r\n" +
+			"--" + tc.boundary + "\r\n" +
+			"Content-Type: text/plain\r\n" +
+			"\r\n" +
+			"two\r\n" +
+			"--" + tc.boundary + "--\r\n"
+		p, err := ReadParts(strings.NewReader(raw))
+		if err != nil {
+			t.Errorf("%s: Unexpected parse error: %v", tc.ctype, err)
+			continue
+		}
+		if got := p.ContentTypeParams()[hpBoundary]; got != tc.boundary {
+			t.Errorf("%s: boundary got: %q, want: %q", tc.ctype, got, tc.boundary)
+		}
+		if len(p.Errors) > 0 {
+			t.Errorf("%s: Got errors: %v", tc.ctype, p.Errors)
+		}
+		want := "multipart/mixed[text/plain text/plain]"
+		if got := partTree(p); got != want {
+			t.Errorf("%s: Part tree got: %q, want: %q", tc.ctype, got, want)
+		}
+	}
+}
+
+func TestRegisterMediaTypeFixer(t *testing.T) {
+	raw := "Content-Type: multipart/mixed; boundary=Enmime\r\n" +
+		"\r\n" +
+		"--Enmime\r\n" +
+		"Content-Type: ;text/plain;;charset=utf-8\r\n" +
+		"\r\n" +
+		"Hello\r\n" +
+		"--Enmime--\r\n"
+	if _, err := ReadParts(strings.NewReader(raw)); err == nil {
+		t.Fatal("Malformed Content-Type parsed without a fixer")
+	}
+
+	defer func() {
+		mediaTypeFixersMu.Lock()
+		mediaTypeFixers = nil
+		mediaTypeFixersMu.Unlock()
+	}()
+	var calls []string
+	RegisterMediaTypeFixer(func(raw string) string {
+		calls = append(calls, "first")
+		return strings.TrimPrefix(raw, ";")
+	})
+	RegisterMediaTypeFixer(func(raw string) string {
+		calls = append(calls, "second")
+		return strings.Replace(raw, ";;", ";", -1)
+	})
+
+	p, err := ReadParts(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Unexpected parse error:", err)
+	}
+	if p.FirstChild == nil {
+		t.Fatal("Child node should not be nil")
+	}
+	wantp := &Part{
+		Parent:      partExists,
+		ContentType: "text/plain",
+		Charset:     "utf-8",
+	}
+	comparePart(p.FirstChild, wantp, func(field, got, want string) {
+		t.Errorf("Part.%s == %q, want: %q", field, got, want)
+	})
+	if len(calls) < 2 || calls[0] != "first" || calls[1] != "second" {
+		t.Errorf("Fixers called in order %q, want first then second", calls)
+	}
+}
@@ -22,6 +22,8 @@ func TestQPCleaner(t *testing.T) {
 		{"Stuffs’s", "Stuffs=E2=80=99s"},
 		{"=", "=3D"},
 		{"=a", "=3Da"},
+		{"soft=\nbreak", "soft=\nbreak"},
+		{"soft=\r\nbreak", "soft=\r\nbreak"},
 	}
 
 	for _, tc := range ttable {
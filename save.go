@@ -0,0 +1,122 @@
+package enmime
+
+import (
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	// maxSaveAttempts limits the number of alternative names Save will try when a file already
+	// exists.
+	maxSaveAttempts = 1000
+	// maxFileNameLen is the length in bytes SanitizedFileName trims names to, the limit of most
+	// file systems.
+	maxFileNameLen = 255
+	// defaultFileName is used by SanitizedFileName when a part has no usable file name.
+	defaultFileName = "attachment"
+)
+
+var (
+	fileNameSanitizerMu sync.RWMutex
+	fileNameSanitizer   = defaultSanitizedFileName
+)
+
+// SetFileNameSanitizer replaces the policy used by Part.SanitizedFileName, and therefore
+// Part.Save, to derive a safe file name from a Part.  Passing nil restores the default policy.
+func SetFileNameSanitizer(fn func(p *Part) string) {
+	fileNameSanitizerMu.Lock()
+	defer fileNameSanitizerMu.Unlock()
+	if fn == nil {
+		fn = defaultSanitizedFileName
+	}
+	fileNameSanitizer = fn
+}
+
+// SanitizedFileName returns FileName in a form that is safe to use as the name of a file on any
+// common operating system.  By default any directory components are removed, control characters
+// and the characters reserved by Windows (<>:"/\|?*) are replaced with underscores, leading and
+// trailing dots and spaces are removed, and the name is trimmed to 255 bytes while retaining its
+// extension.  If nothing usable remains, "attachment" is returned with an extension chosen from
+// the ContentType.  The policy may be replaced with SetFileNameSanitizer.
+func (p *Part) SanitizedFileName() string {
+	fileNameSanitizerMu.RLock()
+	fn := fileNameSanitizer
+	fileNameSanitizerMu.RUnlock()
+	return fn(p)
+}
+
+// defaultSanitizedFileName implements the default policy of SanitizedFileName.
+func defaultSanitizedFileName(p *Part) string {
+	name := p.FileName
+	if i := strings.LastIndexAny(name, `/\`); i != -1 {
+		name = name[i+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.Trim(name, " .")
+	if name == "" {
+		name = defaultFileName
+		if exts, err := mime.ExtensionsByType(p.ContentType); err == nil && len(exts) > 0 {
+			name += exts[0]
+		}
+	}
+	return truncateFileName(name, maxFileNameLen)
+}
+
+// truncateFileName shortens name to at most max bytes, without splitting a UTF-8 sequence.  The
+// extension is retained when it is short enough to leave room for part of the base name.
+func truncateFileName(name string, max int) string {
+	if len(name) <= max {
+		return name
+	}
+	ext := filepath.Ext(name)
+	if len(ext) >= max/2 {
+		ext = ""
+	}
+	base := name[:max-len(ext)]
+	for len(base) > 0 && !utf8.ValidString(base) {
+		base = base[:len(base)-1]
+	}
+	return base + ext
+}
+
+// Save writes the decoded Content of p into dir, returning the path of the new file.  The file is
+// named using SanitizedFileName.  Existing files are never overwritten; a numeric suffix is added
+// to the name instead.  An error is returned if the sanitized name would place the file outside
+// of dir.
+func (p *Part) Save(dir string) (path string, err error) {
+	name := p.SanitizedFileName()
+	if name == "" || name == "." || name == ".." || name != filepath.Base(name) ||
+		filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("unsafe file name %q for part %q", name, p.FileName)
+	}
+
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 0; i < maxSaveAttempts; i++ {
+		path = filepath.Join(dir, name)
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			name = fmt.Sprintf("%s-%d%s", base, i+1, ext)
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if err := p.writeContent(f); err != nil {
+			_ = f.Close()
+			return "", err
+		}
+		return path, f.Close()
+	}
+	return "", fmt.Errorf("unable to find an unused file name for %q in %q", p.FileName, dir)
+}
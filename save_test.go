@@ -0,0 +1,142 @@
+package enmime
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestPartSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "enmime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	testCases := []struct {
+		name     string
+		part     *Part
+		wantName string
+	}{
+		{
+			name:     "normal",
+			part:     &Part{FileName: "report.pdf", ContentType: "application/pdf"},
+			wantName: "report.pdf",
+		},
+		{
+			name:     "duplicate",
+			part:     &Part{FileName: "report.pdf", ContentType: "application/pdf"},
+			wantName: "report-1.pdf",
+		},
+		{
+			name:     "path traversal",
+			part:     &Part{FileName: "../../etc/passwd", ContentType: "text/plain"},
+			wantName: "passwd",
+		},
+		{
+			name:     "windows path traversal",
+			part:     &Part{FileName: `..\..\evil.exe`, ContentType: "application/octet-stream"},
+			wantName: "evil.exe",
+		},
+		{
+			name:     "parent directory only",
+			part:     &Part{FileName: "..", ContentType: "image/png"},
+			wantName: "attachment.png",
+		},
+		{
+			name:     "no filename",
+			part:     &Part{ContentType: "image/png"},
+			wantName: "attachment-1.png",
+		},
+	}
+	for _, tc := range testCases {
+		tc.part.Content = []byte("content of " + tc.name)
+		path, err := tc.part.Save(dir)
+		if err != nil {
+			t.Errorf("%s: Save() returned error: %v", tc.name, err)
+			continue
+		}
+		if want := filepath.Join(dir, tc.wantName); path != want {
+			t.Errorf("%s: Save() path got: %q, want: %q", tc.name, path, want)
+		}
+		got, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if string(got) != string(tc.part.Content) {
+			t.Errorf("%s: saved content got: %q, want: %q", tc.name, got, tc.part.Content)
+		}
+	}
+
+	// Nothing may have been written outside of dir
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != len(testCases) {
+		t.Errorf("Got %v files in dir, want %v", len(files), len(testCases))
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "passwd")); err == nil {
+		t.Error("Save() wrote outside of dir")
+	}
+}
+
+func TestPartSaveUnsafeSanitizer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "enmime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer SetFileNameSanitizer(nil)
+	SetFileNameSanitizer(func(p *Part) string { return p.FileName })
+	p := &Part{FileName: "../escaped.txt", Content: []byte("content")}
+	if path, err := p.Save(dir); err == nil {
+		t.Errorf("Save() with unsafe name returned path %q, want error", path)
+	}
+}
+
+func TestSanitizedFileName(t *testing.T) {
+	longBase := strings.Repeat("é", 200)
+	testCases := []struct {
+		fileName, contentType, want string
+	}{
+		{"plain.txt", "text/plain", "plain.txt"},
+		{"dir/sub/file.txt", "text/plain", "file.txt"},
+		{`C:\Users\a\file.txt`, "text/plain", "file.txt"},
+		{"C:file.txt", "text/plain", "C_file.txt"},
+		{`a<b>c:d"e|f?g*h.txt`, "text/plain", "a_b_c_d_e_f_g_h.txt"},
+		{"../", "image/png", "attachment.png"},
+		{"bad\x00name.txt", "text/plain", "bad_name.txt"},
+		{"line\r\nbreak.txt", "text/plain", "line__break.txt"},
+		{"  .hidden.txt. ", "text/plain", "hidden.txt"},
+		{"", "image/png", "attachment.png"},
+		{"..", "image/png", "attachment.png"},
+		{"", "application/x-enmime-unknown", "attachment"},
+		{longBase + ".pdf", "application/pdf", longBase[:250] + ".pdf"},
+	}
+	for _, tc := range testCases {
+		p := &Part{FileName: tc.fileName, ContentType: tc.contentType}
+		got := p.SanitizedFileName()
+		if got != tc.want {
+			t.Errorf("SanitizedFileName() for %q got: %q, want: %q", tc.fileName, got, tc.want)
+		}
+		if len(got) > maxFileNameLen || !utf8.ValidString(got) {
+			t.Errorf("SanitizedFileName() for %q returned invalid name %q", tc.fileName, got)
+		}
+	}
+
+	defer SetFileNameSanitizer(nil)
+	SetFileNameSanitizer(func(p *Part) string { return strings.ToUpper(p.FileName) })
+	if got := (&Part{FileName: "a/b.txt"}).SanitizedFileName(); got != "A/B.TXT" {
+		t.Errorf("SanitizedFileName() with custom policy got: %q, want: %q", got, "A/B.TXT")
+	}
+	SetFileNameSanitizer(nil)
+	if got := (&Part{FileName: "a/b.txt"}).SanitizedFileName(); got != "b.txt" {
+		t.Errorf("SanitizedFileName() after reset got: %q, want: %q", got, "b.txt")
+	}
+}
@@ -0,0 +1,102 @@
+package enmime
+
+// SizeCategory is a coarse classification of message size, useful for deciding whether to load
+// attachments.
+type SizeCategory int
+
+const (
+	// SizeSmall messages are smaller than the medium size threshold
+	SizeSmall SizeCategory = iota
+	// SizeMedium messages are at least the medium size threshold, but smaller than the large
+	SizeMedium
+	// SizeLarge messages are at least the large size threshold
+	SizeLarge
+)
+
+const (
+	// DefaultMediumSizeThreshold is the TotalSize in bytes at which a message is considered
+	// SizeMedium, unless Parser.MediumSizeThreshold is set.
+	DefaultMediumSizeThreshold = 100 * 1024
+	// DefaultLargeSizeThreshold is the TotalSize in bytes at which a message is considered
+	// SizeLarge, unless Parser.LargeSizeThreshold is set.
+	DefaultLargeSizeThreshold = 5 * 1024 * 1024
+)
+
+// String returns the name of the size category.
+func (c SizeCategory) String() string {
+	switch c {
+	case SizeSmall:
+		return "Small"
+	case SizeMedium:
+		return "Medium"
+	case SizeLarge:
+		return "Large"
+	}
+	return "Unknown"
+}
+
+// TotalSize returns the size of the message in bytes: the sum of the decoded content of all leaf
+// parts, plus the header bytes of every part.
+func (e *Envelope) TotalSize() int {
+	root := e.messageRoot()
+	if root == nil {
+		return 0
+	}
+	total := 0
+	for _, p := range root.DepthMatchAll(func(p *Part) bool { return true }) {
+		total += p.headerSize()
+		if p.FirstChild == nil {
+			total += int(p.contentSize())
+		}
+	}
+	return total
+}
+
+// Size returns the number of bytes in the original input the message was parsed from, or zero if
+// the Envelope was not created by parsing.
+func (e *Envelope) Size() int64 {
+	root := e.messageRoot()
+	if root == nil {
+		return 0
+	}
+	return root.EndOffset
+}
+
+// SizeCategory classifies the TotalSize of the message using the size thresholds of the Parser it
+// was parsed with, see Parser.MediumSizeThreshold.
+func (e *Envelope) SizeCategory() SizeCategory {
+	medium, large := DefaultMediumSizeThreshold, DefaultLargeSizeThreshold
+	if e.opts != nil && e.opts.MediumSizeThreshold > 0 {
+		medium = e.opts.MediumSizeThreshold
+	}
+	if e.opts != nil && e.opts.LargeSizeThreshold > 0 {
+		large = e.opts.LargeSizeThreshold
+	}
+	size := e.TotalSize()
+	switch {
+	case size >= large:
+		return SizeLarge
+	case size >= medium:
+		return SizeMedium
+	}
+	return SizeSmall
+}
+
+// headerSize returns the number of bytes in the header of p.  The original header lines are
+// measured when available, otherwise the size is computed from the Header map.
+func (p *Part) headerSize() int {
+	size := 0
+	if p.rawHeader != nil {
+		for _, f := range p.rawHeader {
+			size += len(f.raw)
+		}
+		return size
+	}
+	for k, values := range p.Header {
+		for _, v := range values {
+			// "Name: value\r\n"
+			size += len(k) + len(v) + 4
+		}
+	}
+	return size
+}
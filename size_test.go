@@ -0,0 +1,100 @@
+package enmime
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEnvelopeTotalSize(t *testing.T) {
+	header := "Content-Type: multipart/mixed; boundary=Enmime\r\n"
+	textHeader := "Content-Type: text/plain\r\n"
+	attHeader := "Content-Type: application/octet-stream\r\n" +
+		"Content-Transfer-Encoding: base64\r\n"
+	raw := header +
+		"\r\n" +
+		"--Enmime\r\n" +
+		textHeader +
+		"\r\n" +
+		"Hello\r\n" +
+		"--Enmime\r\n" +
+		attHeader +
+		"\r\n" +
+		"AAECAwQFBgcICQ==\r\n" +
+		"--Enmime--\r\n"
+	e, err := ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := len(header) + len(textHeader) + len("Hello") + len(attHeader) + 10
+	if got := e.TotalSize(); got != want {
+		t.Errorf("TotalSize() == %v, want: %v", got, want)
+	}
+	if got := e.SizeCategory(); got != SizeSmall {
+		t.Errorf("SizeCategory() == %v, want: %v", got, SizeSmall)
+	}
+
+	// The content of a binary-only body is not held in Root
+	raw = attHeader + "\r\n" + "AAECAwQFBgcICQ==\r\n"
+	if e, err = ReadEnvelope(strings.NewReader(raw)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := e.TotalSize(), len(attHeader)+10; got != want {
+		t.Errorf("TotalSize() of binary-only body == %v, want: %v", got, want)
+	}
+}
+
+func TestEnvelopeSizeCategory(t *testing.T) {
+	raw := "Content-Type: text/plain\r\n\r\n" + strings.Repeat("x", 100)
+	e, err := ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := e.TotalSize()
+
+	testCases := []struct {
+		medium, large int
+		want          SizeCategory
+	}{
+		{size + 1, size + 2, SizeSmall},
+		{size, size + 1, SizeMedium},
+		{size - 1, size, SizeLarge},
+	}
+	for _, tc := range testCases {
+		p := &Parser{MediumSizeThreshold: tc.medium, LargeSizeThreshold: tc.large}
+		e, err := p.ReadEnvelope(strings.NewReader(raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := e.SizeCategory(); got != tc.want {
+			t.Errorf("SizeCategory() with thresholds %v/%v == %v, want: %v",
+				tc.medium, tc.large, got, tc.want)
+		}
+	}
+}
+
+func TestEnvelopeSize(t *testing.T) {
+	raw := "From: alice@example.com\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"Hello\r\n"
+	e, err := ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := e.Size(); got != int64(len(raw)) {
+		t.Errorf("Size() got: %v, want: %v", got, len(raw))
+	}
+	raw = "Content-Type: application/octet-stream\r\n" +
+		"\r\n" +
+		"Binary\r\n"
+	if e, err = ReadEnvelope(strings.NewReader(raw)); err != nil {
+		t.Fatal(err)
+	}
+	if got := e.Size(); got != int64(len(raw)) {
+		t.Errorf("Size() of binary-only body got: %v, want: %v", got, len(raw))
+	}
+	if got := (&Envelope{}).Size(); got != 0 {
+		t.Errorf("Size() of empty Envelope got: %v, want: 0", got)
+	}
+}
@@ -0,0 +1,33 @@
+package enmime
+
+import "strings"
+
+// smimeType returns the smime-type parameter of an S/MIME application/pkcs7-mime part, ie
+// "enveloped-data" or "signed-data", or an empty string for other parts.
+func smimeType(ctype string, params map[string]string) string {
+	if ctype != ctAppPKCS7Mime && ctype != ctAppXPKCS7Mime {
+		return ""
+	}
+	return params[hpSMIMEType]
+}
+
+// IsEncrypted returns true if the message contains encrypted content: an S/MIME part with an
+// smime-type of enveloped-data or authEnveloped-data, or a multipart/encrypted part as used by
+// PGP/MIME.  The content is not decrypted; this is intended for routing and display decisions.
+func (e *Envelope) IsEncrypted() bool {
+	encrypted := func(p *Part) bool {
+		return p.ContentType == ctMultipartEncrypted ||
+			strings.EqualFold(p.SMIMEType, "enveloped-data") ||
+			strings.EqualFold(p.SMIMEType, "authEnveloped-data")
+	}
+	if e.Root != nil && e.Root.DepthMatchFirst(encrypted) != nil {
+		return true
+	}
+	// A message consisting only of an attachment is not part of the Root tree
+	for _, p := range e.Attachments {
+		if encrypted(p) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package enmime
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSMIMEType(t *testing.T) {
+	testCases := []struct {
+		name, raw, smimeType string
+		encrypted            bool
+	}{
+		{
+			name: "enveloped",
+			raw: "Content-Type: application/pkcs7-mime; smime-type=enveloped-data;\r\n" +
+				" name=smime.p7m\r\n" +
+				"Content-Disposition: attachment; filename=smime.p7m\r\n" +
+				"Content-Transfer-Encoding: base64\r\n" +
+				"\r\n" +
+				"MIAGCSqGSIb3DQEHA6CAMIACAQAxggHXMIIB0wIBADA7\r\n",
+			smimeType: "enveloped-data",
+			encrypted: true,
+		},
+		{
+			name: "signed",
+			raw: "Content-Type: application/x-pkcs7-mime; smime-type=signed-data; name=smime.p7m\r\n" +
+				"Content-Transfer-Encoding: base64\r\n" +
+				"\r\n" +
+				"MIAGCSqGSIb3DQEHAqCAMIACAQExDzANBglghkgBZQME\r\n",
+			smimeType: "signed-data",
+			encrypted: false,
+		},
+		{
+			name: "plain",
+			raw: "Content-Type: text/plain; smime-type=enveloped-data\r\n" +
+				"\r\n" +
+				"Hello\r\n",
+			smimeType: "",
+			encrypted: false,
+		},
+	}
+	for _, tc := range testCases {
+		e, err := ReadEnvelope(strings.NewReader(tc.raw))
+		if err != nil {
+			t.Fatalf("%s: Failed to parse MIME: %v", tc.name, err)
+		}
+		// A message consisting only of an attachment is found in Attachments
+		p := e.Root
+		if len(e.Attachments) > 0 {
+			p = e.Attachments[0]
+		}
+		if p.SMIMEType != tc.smimeType {
+			t.Errorf("%s: SMIMEType got: %q, want: %q", tc.name, p.SMIMEType, tc.smimeType)
+		}
+		if e.IsEncrypted() != tc.encrypted {
+			t.Errorf("%s: IsEncrypted() got: %v, want: %v", tc.name, e.IsEncrypted(), tc.encrypted)
+		}
+	}
+}
+
+func TestSMIMETypeNested(t *testing.T) {
+	raw := "Content-Type: multipart/mixed; boundary=Enmime\r\n" +
+		"\r\n" +
+		"--Enmime\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"See attached\r\n" +
+		"--Enmime\r\n" +
+		"Content-Type: application/pkcs7-mime; smime-type=enveloped-data; name=smime.p7m\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		"MIAGCSqGSIb3DQEHA6CAMIACAQAxggHXMIIB0wIBADA7\r\n" +
+		"--Enmime--\r\n"
+	e, err := ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+	if len(e.Attachments) != 1 || e.Attachments[0].SMIMEType != "enveloped-data" {
+		t.Errorf("Attachments got: %v, want one with SMIMEType %q", len(e.Attachments),
+			"enveloped-data")
+	}
+	if !e.IsEncrypted() {
+		t.Error("IsEncrypted() got: false, want: true")
+	}
+
+	if (&Envelope{}).IsEncrypted() {
+		t.Error("IsEncrypted() of empty Envelope got: true, want: false")
+	}
+}
@@ -0,0 +1,136 @@
+package enmime
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// spillContent reads the decoded content of p from r.  Content of up to threshold bytes is
+// returned in memory; larger content is written to a temporary file, recorded in TempPath and
+// opts, and nil is returned.
+func (p *Part) spillContent(r io.Reader, threshold int64, opts *Parser) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if _, err := buf.ReadFrom(io.LimitReader(r, threshold+1)); err != nil {
+		return buf.Bytes(), err
+	}
+	if int64(buf.Len()) <= threshold {
+		return buf.Bytes(), nil
+	}
+
+	f, err := ioutil.TempFile("", "enmime")
+	if err != nil {
+		return nil, err
+	}
+	p.TempPath = f.Name()
+	opts.tempPaths = append(opts.tempPaths, p.TempPath)
+	if _, err = buf.WriteTo(f); err == nil {
+		_, err = io.Copy(f, r)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return nil, err
+}
+
+// tempFileReader reads the content of a temporary file, opening it on the first call to Read and
+// closing it once the content has been read.
+type tempFileReader struct {
+	path string
+	f    *os.File
+	done bool
+}
+
+// Read method for io.Reader interface.
+func (t *tempFileReader) Read(b []byte) (n int, err error) {
+	if t.done {
+		return 0, io.EOF
+	}
+	if t.f == nil {
+		if t.f, err = os.Open(t.path); err != nil {
+			return 0, err
+		}
+	}
+	n, err = t.f.Read(b)
+	if err != nil {
+		_ = t.f.Close()
+		t.done = true
+	}
+	return n, err
+}
+
+// contentReader returns a reader of the decoded content of p, reading from TempPath if the content
+// was too large to be held in memory.
+func (p *Part) contentReader() (io.ReadCloser, error) {
+	if p.TempPath != "" {
+		return os.Open(p.TempPath)
+	}
+	return ioutil.NopCloser(bytes.NewReader(p.Content)), nil
+}
+
+// writeContent writes the decoded content of p to w.
+func (p *Part) writeContent(w io.Writer) error {
+	r, err := p.contentReader()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// readContent returns the decoded content of p, reading it from TempPath if the content was too
+// large to be held in memory.
+func (p *Part) readContent() ([]byte, error) {
+	if p.TempPath != "" {
+		return ioutil.ReadFile(p.TempPath)
+	}
+	return p.Content, nil
+}
+
+// contentSize returns the length of the decoded content of p, wherever it is held.
+func (p *Part) contentSize() int64 {
+	if p.TempPath != "" {
+		if fi, err := os.Stat(p.TempPath); err == nil {
+			return fi.Size()
+		}
+		return 0
+	}
+	return int64(len(p.Content))
+}
+
+// removeTempFiles removes the temporary files created during the parse p holds the state of.  The
+// first error encountered is returned.
+func (p *Parser) removeTempFiles() error {
+	var first error
+	for _, path := range p.tempPaths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) && first == nil {
+			first = err
+		}
+	}
+	p.tempPaths = nil
+	return first
+}
+
+// Cleanup removes the temporary files holding the content of parts that exceeded
+// Parser.InMemoryThreshold; their content is no longer available afterwards.  Callers that set
+// the threshold are responsible for calling Cleanup once they are done with the Envelope.  The
+// first error encountered is returned.
+func (e *Envelope) Cleanup() error {
+	if e.opts == nil {
+		return nil
+	}
+	err := e.opts.removeTempFiles()
+	if root := e.messageRoot(); root != nil {
+		for _, p := range root.DepthMatchAll(func(p *Part) bool { return p.TempPath != "" }) {
+			p.TempPath = ""
+			p.utf8Reader = nil
+		}
+	}
+	for _, inner := range e.embedded {
+		// The files were removed above, this clears the fields of the embedded parts
+		_ = inner.Cleanup()
+	}
+	return err
+}
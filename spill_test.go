@@ -0,0 +1,159 @@
+package enmime
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestInMemoryThreshold(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 1024)
+	encoded := base64.StdEncoding.EncodeToString(data)
+	raw := "Content-Type: multipart/mixed; boundary=Enmime\r\n" +
+		"\r\n" +
+		"--Enmime\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		strings.Repeat("Text content is always kept in memory. ", 100) + "\r\n" +
+		"--Enmime\r\n" +
+		"Content-Type: application/octet-stream\r\n" +
+		"Content-Disposition: attachment; filename=small.bin\r\n" +
+		"\r\n" +
+		"small\r\n" +
+		"--Enmime\r\n" +
+		"Content-Type: application/octet-stream\r\n" +
+		"Content-Disposition: attachment; filename=large.bin\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		encoded + "\r\n" +
+		"--Enmime--\r\n"
+	e, err := (&Parser{InMemoryThreshold: 1024}).ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+	defer e.Cleanup()
+
+	if !strings.HasPrefix(e.Text, "Text content") {
+		t.Errorf("Text got: %.20q, want the text part", e.Text)
+	}
+	if len(e.Attachments) != 2 {
+		t.Fatalf("Got %v attachments, want 2", len(e.Attachments))
+	}
+	small, large := e.Attachments[0], e.Attachments[1]
+	if small.TempPath != "" || string(small.Content) != "small" {
+		t.Errorf("Small attachment got TempPath %q, Content %q, want it in memory",
+			small.TempPath, small.Content)
+	}
+	if large.TempPath == "" || large.Content != nil {
+		t.Fatalf("Large attachment got TempPath %q, %v bytes of Content, want it spilled",
+			large.TempPath, len(large.Content))
+	}
+	got, err := ioutil.ReadFile(large.TempPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("TempPath content does not match, got %v bytes, want %v", len(got), len(data))
+	}
+	if got, err = ioutil.ReadAll(large); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("Reading Part got %v bytes, err %v, want %v bytes", len(got), err, len(data))
+	}
+	sum := sha256.Sum256(data)
+	digests, err := e.AttachmentDigests()
+	if err != nil || len(digests) != 2 || digests[1].SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("AttachmentDigests() got: %v, %v, want digest of the spilled content",
+			digests, err)
+	}
+
+	path := large.TempPath
+	if err := e.Cleanup(); err != nil {
+		t.Fatal("Cleanup() error:", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Temporary file %q still exists after Cleanup(), err: %v", path, err)
+	}
+	if large.TempPath != "" {
+		t.Errorf("TempPath got: %q after Cleanup(), want empty", large.TempPath)
+	}
+}
+
+func TestInMemoryThresholdContentConsumers(t *testing.T) {
+	data := bytes.Repeat([]byte{0, 1, 2, 0xfe, 0xff}, 1024)
+	raw := "Content-Type: multipart/related; boundary=Enmime\r\n" +
+		"\r\n" +
+		"--Enmime\r\n" +
+		"Content-Type: text/html\r\n" +
+		"\r\n" +
+		"<img src=\"cid:large\">\r\n" +
+		"--Enmime\r\n" +
+		"Content-Type: image/png\r\n" +
+		"Content-ID: <large>\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		base64.StdEncoding.EncodeToString(data) + "\r\n" +
+		"--Enmime--\r\n"
+	p := &Parser{InMemoryThreshold: 1024}
+	e, err := p.ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+	defer e.Cleanup()
+	if len(e.Inlines) != 1 || e.Inlines[0].TempPath == "" {
+		t.Fatalf("Got %v inlines, want 1 spilled inline", len(e.Inlines))
+	}
+
+	if got := e.TotalSize(); got < len(data) {
+		t.Errorf("TotalSize() got: %v, want at least %v", got, len(data))
+	}
+	want := "data:image/png;base64," + base64.StdEncoding.EncodeToString(data)
+	if got := e.InlineHTML(); !strings.Contains(got, want) {
+		t.Errorf("InlineHTML() got: %.60q, want the spilled content as a data URI", got)
+	}
+
+	b := &bytes.Buffer{}
+	if err := e.Encode(b); err != nil {
+		t.Fatal("Encode() error:", err)
+	}
+	re, err := ReadEnvelope(b)
+	if err != nil {
+		t.Fatal("Failed to parse encoded MIME:", err)
+	}
+	if len(re.Inlines) != 1 || !bytes.Equal(re.Inlines[0].Content, data) {
+		t.Error("Encoded message does not contain the spilled content")
+	}
+}
+
+func TestInMemoryThresholdBinaryOnlyCleanup(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 1024)
+	raw := "Content-Type: application/octet-stream\r\n" +
+		"Content-Disposition: attachment; filename=large.bin\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		base64.StdEncoding.EncodeToString(data) + "\r\n"
+	e, err := (&Parser{InMemoryThreshold: 1024}).ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+	if len(e.Attachments) != 1 || e.Attachments[0].TempPath == "" {
+		t.Fatalf("Got %v attachments, want 1 spilled attachment", len(e.Attachments))
+	}
+	if got := e.TotalSize(); got < len(data) {
+		t.Errorf("TotalSize() got: %v, want at least %v", got, len(data))
+	}
+
+	path := e.Attachments[0].TempPath
+	if err := e.Cleanup(); err != nil {
+		t.Fatal("Cleanup() error:", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Temporary file %q still exists after Cleanup(), err: %v", path, err)
+	}
+	if e.Attachments[0].TempPath != "" {
+		t.Errorf("TempPath got: %q after Cleanup(), want empty", e.Attachments[0].TempPath)
+	}
+}
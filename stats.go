@@ -0,0 +1,32 @@
+package enmime
+
+import "time"
+
+// ParseStats holds counters describing the parsing of a message, for use in metrics.
+type ParseStats struct {
+	Parts        int           // Number of parts in the message, including the root
+	DecodedBytes int64         // Total size of the decoded content of all parts
+	Warnings     int           // Number of non-severe Errors recorded
+	Errors       int           // Number of severe Errors recorded
+	Duration     time.Duration // Time taken to parse the message, zero if not parsed by a Parser
+}
+
+// collectStats populates the counters of e.Stats from the Part tree and Errors; Duration is left
+// to the caller.
+func (e *Envelope) collectStats() {
+	e.Stats = ParseStats{}
+	if root := e.messageRoot(); root != nil {
+		_ = root.Walk(func(p *Part) error {
+			e.Stats.Parts++
+			e.Stats.DecodedBytes += p.contentSize()
+			return nil
+		})
+	}
+	for _, perr := range e.Errors {
+		if perr.Severe {
+			e.Stats.Errors++
+		} else {
+			e.Stats.Warnings++
+		}
+	}
+}
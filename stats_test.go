@@ -0,0 +1,45 @@
+package enmime
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEnvelopeStats(t *testing.T) {
+	e, err := ReadEnvelope(openTestData("mail", "mime-mixed-alternative-pdf.raw"))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+	// root, alternative, text, html and pdf
+	if e.Stats.Parts != 5 {
+		t.Errorf("Stats.Parts got: %v, want: %v", e.Stats.Parts, 5)
+	}
+	want := int64(len(e.Text) + len(e.HTML) + len("%PDF-1.4\n%%EOF\n"))
+	if e.Stats.DecodedBytes != want {
+		t.Errorf("Stats.DecodedBytes got: %v, want: %v", e.Stats.DecodedBytes, want)
+	}
+	if e.Stats.Warnings != 0 || e.Stats.Errors != 0 {
+		t.Errorf("Stats got %v warnings and %v errors, want none", e.Stats.Warnings, e.Stats.Errors)
+	}
+	if e.Stats.Duration < 0 {
+		t.Errorf("Stats.Duration got: %v, want >= 0", e.Stats.Duration)
+	}
+
+	// Missing Content-Type is a warning, a truncated part is an error
+	raw := "Subject: hi\r\n\r\nBody\r\n"
+	e, err = ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+	if e.Stats.Parts != 1 || e.Stats.Warnings != 1 || e.Stats.Errors != 0 {
+		t.Errorf("Stats got: %+v, want 1 part and 1 warning", e.Stats)
+	}
+	raw = "Content-Type: multipart/mixed; boundary=Enmime\r\n\r\n--Enmime\r\n"
+	e, err = ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+	if e.Stats.Errors != 1 {
+		t.Errorf("Stats got: %+v, want 1 error", e.Stats)
+	}
+}
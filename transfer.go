@@ -0,0 +1,149 @@
+package enmime
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strings"
+	"sync"
+)
+
+// TransferDecoder wraps a reader of encoded part content, returning a reader of the decoded
+// content.
+type TransferDecoder func(r io.Reader) io.Reader
+
+var (
+	transferDecodersMu sync.RWMutex
+	transferDecoders   = make(map[string]TransferDecoder)
+)
+
+// RegisterTransferDecoder registers fn as the decoder for part bodies with the named
+// Content-Transfer-Encoding, allowing nonstandard encodings to be supported.  Names are case
+// insensitive.  A registered decoder takes precedence over enmime's built-in handling of base64,
+// quoted-printable and identity encodings.
+func RegisterTransferDecoder(name string, fn func(io.Reader) io.Reader) {
+	transferDecodersMu.Lock()
+	defer transferDecodersMu.Unlock()
+	transferDecoders[strings.ToLower(name)] = fn
+}
+
+// lookupTransferDecoder returns the registered decoder for the named Content-Transfer-Encoding, or
+// nil if there is none.
+func lookupTransferDecoder(name string) TransferDecoder {
+	transferDecodersMu.RLock()
+	defer transferDecodersMu.RUnlock()
+	return transferDecoders[strings.ToLower(name)]
+}
+
+// UnknownEncodingReader is returned by NewDecodingReader when the Content-Transfer-Encoding is not
+// recognized.  It reads the body undecoded; callers may detect it with a type assertion.
+type UnknownEncodingReader struct {
+	io.Reader
+	Encoding string // The unrecognized Content-Transfer-Encoding
+}
+
+// NewDecodingReader returns a reader that decodes body according to the Content-Transfer-Encoding
+// in header, supporting base64, quoted-printable, identity encodings and any registered with
+// RegisterTransferDecoder.  Content is decoded as it is read.  If the header lists multiple
+// encodings the last, outermost, is used.  If the encoding is not recognized an
+// *UnknownEncodingReader is returned, passing the body through undecoded.
+func NewDecodingReader(header textproto.MIMEHeader, body io.Reader) io.Reader {
+	encoding := header.Get(hnContentEncoding)
+	if i := strings.LastIndex(encoding, ","); i != -1 {
+		encoding = encoding[i+1:]
+	}
+	encoding = strings.TrimSpace(encoding)
+	r, _, ok := newTransferReader(encoding, body)
+	if !ok {
+		return &UnknownEncodingReader{Reader: body, Encoding: encoding}
+	}
+	return r
+}
+
+// newTransferReader wraps r with a decoder for the named Content-Transfer-Encoding.  The
+// base64Cleaner is returned for base64 content so that the caller may check whether it repaired
+// anything.  If the encoding is not recognized, r is returned along with false.
+func newTransferReader(encoding string, r io.Reader) (io.Reader, *base64Cleaner, bool) {
+	if decoder := lookupTransferDecoder(encoding); decoder != nil {
+		// User registered decoder
+		return decoder(r), nil, true
+	}
+	switch strings.ToLower(encoding) {
+	case "quoted-printable":
+		return quotedprintable.NewReader(newQPCleaner(r)), nil, true
+	case "base64":
+		b64Cleaner := newBase64Cleaner(r)
+		return base64.NewDecoder(base64.StdEncoding, b64Cleaner), b64Cleaner, true
+	case "8bit", "7bit", "binary", "":
+		// No decoding required
+		return r, nil, true
+	}
+	return r, nil, false
+}
+
+// minSniffBase64LineLen is the shortest line length sniffTransferEncoding will accept as base64;
+// encoders wrap lines at 60 to 76 characters, shorter runs are too likely to be ordinary words.
+const minSniffBase64LineLen = 40
+
+// sniffTransferEncoding guesses the Content-Transfer-Encoding of content sent without the header.
+// It returns "base64" when content consists of several lines of base64 wrapped at a consistent
+// length, "quoted-printable" when every "=" in content begins a valid escape or soft line break,
+// and an empty string otherwise.
+func sniffTransferEncoding(content []byte) string {
+	if isBase64Content(content) {
+		return "base64"
+	}
+	if isQuotedPrintableContent(content) {
+		return "quoted-printable"
+	}
+	return ""
+}
+
+// isBase64Content returns true if content looks like multiple lines of base64.
+func isBase64Content(content []byte) bool {
+	lines := bytes.Split(bytes.TrimSpace(content), []byte("\n"))
+	if len(lines) < 2 {
+		return false
+	}
+	width := len(bytes.TrimRight(lines[0], "\r"))
+	if width < minSniffBase64LineLen || width > base64LineLen {
+		return false
+	}
+	count := 0
+	for i, line := range lines {
+		line = bytes.TrimRight(line, "\r")
+		if len(line) == 0 || len(line) > width || (i < len(lines)-1 && len(line) != width) {
+			return false
+		}
+		for _, b := range line {
+			if !isBase64Byte(b) {
+				return false
+			}
+		}
+		count += len(line)
+	}
+	return count%4 == 0
+}
+
+// isQuotedPrintableContent returns true if content contains at least one quoted-printable escape
+// or soft line break, and no "=" that is not part of one.
+func isQuotedPrintableContent(content []byte) bool {
+	found := false
+	for i := bytes.IndexByte(content, '='); i != -1; i = bytes.IndexByte(content, '=') {
+		rest := content[i+1:]
+		switch {
+		case len(rest) >= 2 && isValidHexBytes(rest[:2]):
+			content = rest[2:]
+		case len(rest) >= 1 && rest[0] == '\n':
+			content = rest[1:]
+		case len(rest) >= 2 && rest[0] == '\r' && rest[1] == '\n':
+			content = rest[2:]
+		default:
+			return false
+		}
+		found = true
+	}
+	return found
+}
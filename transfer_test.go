@@ -0,0 +1,182 @@
+package enmime
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+// rot13 decodes ROT13 content, used to test user registered transfer decoders
+func rot13(r io.Reader) io.Reader {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return r
+	}
+	for i, c := range b {
+		switch {
+		case c >= 'a' && c <= 'z':
+			b[i] = 'a' + (c-'a'+13)%26
+		case c >= 'A' && c <= 'Z':
+			b[i] = 'A' + (c-'A'+13)%26
+		}
+	}
+	return bytes.NewReader(b)
+}
+
+func TestRegisterTransferDecoder(t *testing.T) {
+	RegisterTransferDecoder("X-ROT13", rot13)
+	defer func() {
+		transferDecodersMu.Lock()
+		delete(transferDecoders, "x-rot13")
+		transferDecodersMu.Unlock()
+	}()
+
+	raw := "Content-Type: text/plain; charset=us-ascii\r\n" +
+		"Content-Transfer-Encoding: x-rot13\r\n" +
+		"\r\n" +
+		"Uryyb Jbeyq\r\n"
+	p, err := ReadParts(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Unexpected parse error:", err)
+	}
+	if len(p.Errors) > 0 {
+		t.Errorf("Got %v p.Errors, want 0: %v", len(p.Errors), p.Errors)
+	}
+
+	want := "Hello World\r\n"
+	if ok, err := contentEqualsString(p, want); !ok {
+		t.Error("Part", err)
+	}
+}
+
+func TestUnregisteredTransferDecoder(t *testing.T) {
+	raw := "Content-Type: text/plain; charset=us-ascii\r\n" +
+		"Content-Transfer-Encoding: x-rot13\r\n" +
+		"\r\n" +
+		"Uryyb Jbeyq\r\n"
+	p, err := ReadParts(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Unexpected parse error:", err)
+	}
+	if len(p.Errors) != 1 {
+		t.Fatalf("Got %v p.Errors, want 1", len(p.Errors))
+	}
+	if p.Errors[0].Name != string(errorContentEncoding) {
+		t.Errorf("p.Errors[0].Name == %q, want: %q", p.Errors[0].Name, errorContentEncoding)
+	}
+
+	want := "Uryyb Jbeyq\r\n"
+	if ok, err := contentEqualsString(p, want); !ok {
+		t.Error("Part", err)
+	}
+}
+
+func TestSniffTransferEncoding(t *testing.T) {
+	testCases := []struct {
+		name, body, want string
+	}{
+		{
+			name: "base64",
+			body: "VGhpcyBtZXNzYWdlIHdhcyBzZW50IGJ5IGEgY2xpZW50IHRoYXQgZm9yZ290IHRvIGRl\r\n" +
+				"Y2xhcmUgaXRzIHRyYW5zZmVyIGVuY29kaW5nLg==\r\n",
+			want: "This message was sent by a client that forgot to declare its transfer encoding.",
+		},
+		{
+			name: "quoted-printable",
+			body: "Caf=C3=A9 au lait, s'il vous pla=C3=AEt. This line is soft wrapped by the =\r\n" +
+				"sender.\r\n",
+			want: "Café au lait, s'il vous plaît. This line is soft wrapped by the sender.\r\n",
+		},
+		{
+			name: "plain",
+			body: "Total = 4 + 2\r\nNothing to decode here.\r\n",
+			want: "Total = 4 + 2\r\nNothing to decode here.\r\n",
+		},
+	}
+	for _, tc := range testCases {
+		raw := "Content-Type: text/plain; charset=utf-8\r\n\r\n" + tc.body
+		p, err := (&Parser{SniffTransferEncoding: true}).ReadParts(strings.NewReader(raw))
+		if err != nil {
+			t.Fatal(tc.name, err)
+		}
+		if ok, err := contentEqualsString(p, tc.want); !ok {
+			t.Error(tc.name, err)
+		}
+		wantErrors := 1
+		if tc.name == "plain" {
+			wantErrors = 0
+		}
+		if len(p.Errors) != wantErrors {
+			t.Errorf("%s: got %v p.Errors, want %v: %v", tc.name, len(p.Errors), wantErrors, p.Errors)
+		}
+		for _, perr := range p.Errors {
+			if perr.Name != ErrorContentEncoding || !strings.Contains(perr.Detail, tc.name) {
+				t.Errorf("%s: unexpected error: %v", tc.name, perr.String())
+			}
+		}
+
+		// Without the option, content is left as-is
+		p, err = ReadParts(strings.NewReader(raw))
+		if err != nil {
+			t.Fatal(tc.name, err)
+		}
+		if ok, err := contentEqualsString(p, tc.body); !ok {
+			t.Error(tc.name, "without sniffing:", err)
+		}
+	}
+}
+
+func TestNewDecodingReader(t *testing.T) {
+	testCases := []struct {
+		encoding, body, want string
+	}{
+		{"base64", "SGVsbG8g\r\nV29ybGQ=\r\n", "Hello World"},
+		{"Quoted-Printable", "Caf=C3=A9 =\r\nau lait", "Café au lait"},
+		{"7bit", "Hello World", "Hello World"},
+		{"8bit", "Café", "Café"},
+		{"binary", "\x00\x01", "\x00\x01"},
+		{"", "Hello World", "Hello World"},
+		{"7bit, base64", "SGVsbG8gV29ybGQ=", "Hello World"},
+	}
+	for _, tc := range testCases {
+		header := textproto.MIMEHeader{}
+		if tc.encoding != "" {
+			header.Set(hnContentEncoding, tc.encoding)
+		}
+		r := NewDecodingReader(header, strings.NewReader(tc.body))
+		if _, ok := r.(*UnknownEncodingReader); ok {
+			t.Errorf("%q: got UnknownEncodingReader, want decoder", tc.encoding)
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Errorf("%q: read error: %v", tc.encoding, err)
+			continue
+		}
+		if string(got) != tc.want {
+			t.Errorf("%q: got: %q, want: %q", tc.encoding, got, tc.want)
+		}
+	}
+}
+
+func TestNewDecodingReaderUnknown(t *testing.T) {
+	header := textproto.MIMEHeader{}
+	header.Set(hnContentEncoding, "x-uuencode")
+	r := NewDecodingReader(header, strings.NewReader("begin 644 file"))
+	u, ok := r.(*UnknownEncodingReader)
+	if !ok {
+		t.Fatalf("Got %T, want *UnknownEncodingReader", r)
+	}
+	if u.Encoding != "x-uuencode" {
+		t.Errorf("Encoding got: %q, want: %q", u.Encoding, "x-uuencode")
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "begin 644 file" {
+		t.Errorf("Content got: %q, want: %q", got, "begin 644 file")
+	}
+}
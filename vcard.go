@@ -0,0 +1,90 @@
+package enmime
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+)
+
+// VCard holds the decoded content of a vCard (text/vcard) part, along with a few commonly used
+// fields.  It is not a complete vCard parser.
+type VCard struct {
+	Part          *Part    // The Part containing the vCard
+	Raw           []byte   // The decoded vCard content
+	FormattedName string   // The FN property
+	Emails        []string // The EMAIL properties, in order of appearance
+}
+
+// VCards returns the vCards found in the message, including those attached with the legacy
+// text/x-vcard and text/directory content types.
+func (e *Envelope) VCards() []*VCard {
+	root := e.messageRoot()
+	if root == nil {
+		return nil
+	}
+	parts := root.DepthMatchAll(func(p *Part) bool {
+		switch p.ContentType {
+		case ctTextVCard, ctTextXVCard, ctTextDirectory:
+			return true
+		}
+		return false
+	})
+	cards := make([]*VCard, 0, len(parts))
+	for _, p := range parts {
+		card := parseVCard(p.Content)
+		card.Part = p
+		cards = append(cards, card)
+	}
+	return cards
+}
+
+// parseVCard extracts the FN and EMAIL properties from the vCard content in b.
+func parseVCard(b []byte) *VCard {
+	card := &VCard{Raw: b}
+	for _, line := range unfoldVCardLines(b) {
+		colon := strings.IndexByte(line, ':')
+		if colon < 1 {
+			continue
+		}
+		// Strip parameters (EMAIL;TYPE=work) and group (item1.EMAIL) from the property name
+		name := line[:colon]
+		if i := strings.IndexByte(name, ';'); i != -1 {
+			name = name[:i]
+		}
+		if i := strings.LastIndexByte(name, '.'); i != -1 {
+			name = name[i+1:]
+		}
+		value := strings.TrimSpace(line[colon+1:])
+		switch strings.ToUpper(name) {
+		case "FN":
+			if card.FormattedName == "" {
+				card.FormattedName = unescapeVCardValue(value)
+			}
+		case "EMAIL":
+			if value != "" {
+				card.Emails = append(card.Emails, value)
+			}
+		}
+	}
+	return card
+}
+
+// unfoldVCardLines splits b into lines, joining continuation lines that begin with whitespace.
+func unfoldVCardLines(b []byte) []string {
+	var lines []string
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		line := strings.TrimRight(s.Text(), "\r")
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// unescapeVCardValue removes backslash escapes from a vCard text value.
+func unescapeVCardValue(s string) string {
+	return strings.NewReplacer(`\,`, ",", `\;`, ";", `\n`, "\n", `\N`, "\n", `\\`, `\`).Replace(s)
+}
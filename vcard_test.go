@@ -0,0 +1,102 @@
+package enmime
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEnvelopeVCards(t *testing.T) {
+	vcard := "BEGIN:VCARD\r\n" +
+		"VERSION:3.0\r\n" +
+		"N:Doe;Jane;;;\r\n" +
+		"FN:Jane Doe\\, PhD\r\n" +
+		"EMAIL;TYPE=work:jane@exam\r\n" +
+		" ple.com\r\n" +
+		"item1.EMAIL:jdoe@example.org\r\n" +
+		"END:VCARD\r\n"
+	raw := "From: user@inbucket.org\r\n" +
+		"Content-Type: multipart/mixed; boundary=Enmime-100\r\n" +
+		"\r\n" +
+		"--Enmime-100\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"Contact attached\r\n" +
+		"--Enmime-100\r\n" +
+		"Content-Type: text/vcard; charset=utf-8; name=jane.vcf\r\n" +
+		"Content-Disposition: attachment; filename=jane.vcf\r\n" +
+		"\r\n" +
+		vcard +
+		"--Enmime-100--\r\n"
+	e, err := ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+
+	cards := e.VCards()
+	if len(cards) != 1 {
+		t.Fatalf("len(VCards()) == %v, want: 1", len(cards))
+	}
+	card := cards[0]
+	if card.Part == nil || card.Part.FileName != "jane.vcf" {
+		t.Errorf("VCard.Part == %+v, want part with FileName %q", card.Part, "jane.vcf")
+	}
+	// The CRLF preceding the boundary is not part of the content
+	wantRaw := strings.TrimSuffix(vcard, "\r\n")
+	if string(card.Raw) != wantRaw {
+		t.Errorf("VCard.Raw == %q, want: %q", card.Raw, wantRaw)
+	}
+	want := "Jane Doe, PhD"
+	if card.FormattedName != want {
+		t.Errorf("VCard.FormattedName == %q, want: %q", card.FormattedName, want)
+	}
+	wantEmails := []string{"jane@example.com", "jdoe@example.org"}
+	if strings.Join(card.Emails, ",") != strings.Join(wantEmails, ",") {
+		t.Errorf("VCard.Emails == %q, want: %q", card.Emails, wantEmails)
+	}
+}
+
+func TestEnvelopeVCardsOnly(t *testing.T) {
+	raw := "From: user@inbucket.org\r\n" +
+		"Content-Type: text/vcard; charset=utf-8\r\n" +
+		"Content-Disposition: attachment; filename=jane.vcf\r\n" +
+		"\r\n" +
+		"BEGIN:VCARD\r\n" +
+		"VERSION:3.0\r\n" +
+		"FN:Jane Doe\r\n" +
+		"EMAIL:jane@example.com\r\n" +
+		"END:VCARD\r\n"
+	e, err := ReadEnvelope(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+	if len(e.Attachments) != 1 {
+		t.Fatalf("Got %v attachments, want 1", len(e.Attachments))
+	}
+
+	// The vCard is the root of the message, not the empty placeholder Root
+	cards := e.VCards()
+	if len(cards) != 1 {
+		t.Fatalf("len(VCards()) == %v, want: 1", len(cards))
+	}
+	card := cards[0]
+	if card.Part != e.Attachments[0] {
+		t.Errorf("VCard.Part == %+v, want the attachment", card.Part)
+	}
+	if card.FormattedName != "Jane Doe" {
+		t.Errorf("VCard.FormattedName == %q, want: %q", card.FormattedName, "Jane Doe")
+	}
+	if strings.Join(card.Emails, ",") != "jane@example.com" {
+		t.Errorf("VCard.Emails == %q, want: %q", card.Emails, []string{"jane@example.com"})
+	}
+}
+
+func TestEnvelopeVCardsNone(t *testing.T) {
+	msg := openTestData("mail", "attachment.raw")
+	e, err := ReadEnvelope(msg)
+	if err != nil {
+		t.Fatal("Failed to parse MIME:", err)
+	}
+	if cards := e.VCards(); len(cards) != 0 {
+		t.Errorf("len(VCards()) == %v, want: 0", len(cards))
+	}
+}